@@ -12,8 +12,9 @@ fi
 echo "preper..."
 go mod tidy
 echo "build..."
-go build -ldflags "-s -w" ./cmd/sshx
-go build -ldflags "-s -w" ./cmd/signaling
+version=`git describe --tags --always 2>/dev/null`
+go build -ldflags "-s -w -X github.com/suutaku/sshx/pkg/types.Version=${version}" ./cmd/sshx
+go build -ldflags "-s -w -X github.com/suutaku/sshx/pkg/types.Version=${version}" ./cmd/signaling
 echo "$1"
 if [ "$1" = "install" ];then
   echo "build for ${platform}"
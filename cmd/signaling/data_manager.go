@@ -48,27 +48,68 @@ func (dm *DManager) resetAlive(id string) {
 	dm.alive[id] = LIFE_TIME_IN_SECOND
 }
 
-func (dm *DManager) Set(id string, info types.SignalingInfo) {
-	if dm.datas[id] == nil {
-		dm.mu.Lock()
-		dm.datas[id] = make(chan types.SignalingInfo, MAX_BUFFER_NUMBER)
-		dm.mu.Unlock()
-		dm.resetAlive(id)
-		go func(dmc *DManager) {
-			logrus.Debug("create watch dog for ", id)
-			for dmc.alive[id] > 0 {
-				time.Sleep(time.Second)
-				dmc.mu.Lock()
-				dmc.alive[id]--
-				dmc.mu.Unlock()
+// get mailbox of id, create it with a watch dog if not exist
+func (dm *DManager) mailbox(id string) chan types.SignalingInfo {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	return dm.mailboxLocked(id)
+}
+
+// mailboxLocked is mailbox with dm.mu held
+func (dm *DManager) mailboxLocked(id string) chan types.SignalingInfo {
+	if dm.datas[id] != nil {
+		return dm.datas[id]
+	}
+	ch := make(chan types.SignalingInfo, MAX_BUFFER_NUMBER)
+	dm.datas[id] = ch
+	dm.alive[id] = LIFE_TIME_IN_SECOND
+	go func() {
+		logrus.Debug("create watch dog for ", id)
+		for {
+			time.Sleep(time.Second)
+			if dm.countDown(id, ch) {
+				return
 			}
-			logrus.Debug("execute watch dog for ", id)
-			dm.Clean(id)
-		}(dm)
+		}
+	}()
+	return ch
+}
+
+// countDown decreases life time of mailbox ch of id and cleans it once expired,
+// returns true if watch dog of ch should stop
+func (dm *DManager) countDown(id string, ch chan types.SignalingInfo) bool {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	if dm.datas[id] != ch {
+		// cleaned, maybe recreated with another watch dog
+		return true
+	}
+	dm.alive[id]--
+	if dm.alive[id] > 0 {
+		return false
 	}
+	logrus.Debug("execute watch dog for ", id)
+	close(ch)
+	delete(dm.datas, id)
+	delete(dm.alive, id)
+	return true
+}
+
+// Keep returns mailbox of id and resets its life time,
+// stream subscribers call it periodically to hold the mailbox
+func (dm *DManager) Keep(id string) chan types.SignalingInfo {
+	ch := dm.mailbox(id)
+	dm.resetAlive(id)
+	return ch
+}
+
+// Set drops info if mailbox of id is full, sent with dm.mu held so Clean cannot close mailbox meanwhile
+func (dm *DManager) Set(id string, info types.SignalingInfo) {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
 	select {
-	case dm.datas[id] <- info:
-		dm.resetAlive(id)
+	case dm.mailboxLocked(id) <- info:
+		dm.alive[id] = LIFE_TIME_IN_SECOND
 	default:
 	}
 }
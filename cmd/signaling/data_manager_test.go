@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/suutaku/sshx/pkg/types"
+)
+
+func aliveOf(dm *DManager, id string) int {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	return dm.alive[id]
+}
+
+func TestDManagerCountDown(t *testing.T) {
+	dm := NewDManager()
+	ch := dm.mailbox("node")
+	for i := 1; i < LIFE_TIME_IN_SECOND; i++ {
+		if dm.countDown("node", ch) {
+			t.Fatalf("watch dog stopped after %d seconds", i)
+		}
+	}
+	dm.Set("node", types.SignalingInfo{})
+	if aliveOf(dm, "node") != LIFE_TIME_IN_SECOND {
+		t.Errorf("life time = %d after Set", aliveOf(dm, "node"))
+	}
+	for i := 0; i < LIFE_TIME_IN_SECOND-1; i++ {
+		dm.countDown("node", ch)
+	}
+	if !dm.countDown("node", ch) {
+		t.Fatal("watch dog not stopped after life time")
+	}
+	if dm.Get("node") != nil {
+		t.Error("expired mailbox not cleaned")
+	}
+	if _, ok := <-ch; !ok {
+		t.Error("info of expired mailbox lost")
+	}
+	if _, ok := <-ch; ok {
+		t.Error("expired mailbox not closed")
+	}
+}
+
+func TestDManagerRecreated(t *testing.T) {
+	dm := NewDManager()
+	old := dm.mailbox("node")
+	dm.Clean("node")
+	ch := dm.mailbox("node")
+	// watch dog of cleaned mailbox stops without touching the new one
+	if !dm.countDown("node", old) {
+		t.Error("watch dog of cleaned mailbox not stopped")
+	}
+	if aliveOf(dm, "node") != LIFE_TIME_IN_SECOND {
+		t.Errorf("life time of new mailbox = %d", aliveOf(dm, "node"))
+	}
+	if dm.countDown("node", ch) {
+		t.Error("watch dog of new mailbox stopped")
+	}
+}
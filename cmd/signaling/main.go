@@ -1,24 +1,119 @@
 package main
 
 import (
+	"flag"
 	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/suutaku/sshx/internal/utils"
 )
 
+// parse tokens from "token" or "tenant:token,tenant2:token2"
+func parseTokens(shared, tenants string) map[string]string {
+	tokens := make(map[string]string)
+	if shared != "" {
+		tokens[shared] = ""
+	}
+	for _, v := range strings.Split(tenants, ",") {
+		sps := strings.SplitN(strings.TrimSpace(v), ":", 2)
+		if len(sps) != 2 || sps[1] == "" {
+			continue
+		}
+		tokens[sps[1]] = sps[0]
+	}
+	return tokens
+}
+
+// SSHX_TURN_TTL in seconds, default one day
+func defaultTURNTTL() time.Duration {
+	ttl, err := strconv.Atoi(os.Getenv("SSHX_TURN_TTL"))
+	if err != nil || ttl <= 0 {
+		return 24 * time.Hour
+	}
+	return time.Duration(ttl) * time.Second
+}
+
+// SSHX_TURN_PORT, default 3478
+func defaultTURNPort() int {
+	port, err := strconv.Atoi(os.Getenv("SSHX_TURN_PORT"))
+	if err != nil || port <= 0 {
+		return 3478
+	}
+	return port
+}
+
 func main() {
 	port := os.Getenv("SSHX_SIGNALING_PORT")
 	if port == "" {
 		port = "11095"
 	}
-
-	server := NewServer(port)
+	certFile := flag.String("cert", os.Getenv("SSHX_SIGNALING_CERT"), "TLS certificate file")
+	keyFile := flag.String("key", os.Getenv("SSHX_SIGNALING_KEY"), "TLS key file")
+	selfSigned := flag.Bool("self-signed", utils.IsTrue(os.Getenv("SSHX_SIGNALING_SELF_SIGNED")), "generate a self-signed certificate if not exist")
+	hosts := flag.String("hosts", os.Getenv("SSHX_SIGNALING_HOSTS"), "comma separated host names and IPs of self-signed certificate")
+	turnSecret := flag.String("turn-secret", os.Getenv("SSHX_TURN_SECRET"), "secret shared with TURN server, issue TURN credentials if set")
+	turnURIs := flag.String("turn-uris", os.Getenv("SSHX_TURN_URIS"), "comma separated TURN server uris, e.g. turn:example.com:3478")
+	turnTTL := flag.Duration("turn-ttl", defaultTURNTTL(), "lifetime of TURN credentials")
+	turnEmbedded := flag.Bool("turn", utils.IsTrue(os.Getenv("SSHX_TURN")), "run embedded TURN server")
+	turnPort := flag.Int("turn-port", defaultTURNPort(), "UDP and TCP port of embedded TURN server")
+	turnRealm := flag.String("turn-realm", os.Getenv("SSHX_TURN_REALM"), "realm of embedded TURN server")
+	turnIP := flag.String("turn-ip", os.Getenv("SSHX_TURN_IP"), "public ip of embedded TURN server, default first local ip")
+	turnOpen := flag.Bool("turn-open", utils.IsTrue(os.Getenv("SSHX_TURN_OPEN")), "run embedded TURN server without signaling tokens, anyone can relay")
+	flag.Parse()
 
 	if utils.DebugOn() {
 		logrus.SetLevel(logrus.DebugLevel)
 	} else {
 		logrus.SetLevel(logrus.InfoLevel)
 	}
+
+	if *selfSigned {
+		if *certFile == "" {
+			*certFile = path.Join(utils.GetSSHXHome(), "signaling.crt")
+		}
+		if *keyFile == "" {
+			*keyFile = path.Join(utils.GetSSHXHome(), "signaling.key")
+		}
+		if err := ensureSelfSigned(*certFile, *keyFile, *hosts); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+
+	tokens := parseTokens(os.Getenv("SSHX_SIGNALING_TOKEN"), os.Getenv("SSHX_SIGNALING_TOKENS"))
+	if len(tokens) == 0 {
+		logrus.Warn("no token configured, anyone can use this signaling server")
+	}
+
+	server := NewServer(port, tokens)
+	server.SetTLS(*certFile, *keyFile)
+	if *turnEmbedded {
+		if len(tokens) == 0 && !*turnOpen {
+			logrus.Fatal("embedded TURN server needs signaling tokens, set -turn-open to relay for anyone")
+		}
+		if *turnSecret == "" {
+			// only used by this process
+			*turnSecret, _ = utils.MakeRandomStr(32)
+		}
+		if *turnRealm == "" {
+			*turnRealm = "sshx"
+		}
+		if *turnIP == "" {
+			*turnIP = utils.GetLocalIP()
+		}
+		issuer := NewTURNIssuer(*turnSecret, *turnURIs, *turnTTL)
+		if _, err := StartTURNServer(issuer, *turnPort, *turnRealm, *turnIP, server.HasTenant); err != nil {
+			logrus.Fatal(err)
+		}
+		server.SetTURN(issuer)
+	} else if *turnSecret != "" {
+		if *turnURIs == "" {
+			logrus.Fatal("TURN secret set without TURN uris")
+		}
+		server.SetTURN(NewTURNIssuer(*turnSecret, *turnURIs, *turnTTL))
+	}
 	server.Start()
 }
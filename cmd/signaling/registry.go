@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/suutaku/sshx/pkg/types"
+)
+
+const (
+	PEER_TTL_IN_SECOND   = 30
+	MAX_PEERS_PER_TENANT = 1024
+)
+
+// Registry keeps nodes which sent heartbeat recently, grouped by tenant
+type Registry struct {
+	peers map[string]map[string]types.PeerInfo
+	// last time expired nodes of all tenants were dropped
+	swept time.Time
+	mu    sync.Mutex
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		peers: make(map[string]map[string]types.PeerInfo),
+		swept: time.Now(),
+	}
+}
+
+// Register records heartbeat of node, fails if tenant has too many online nodes.
+// Expired nodes of all tenants are dropped once per PEER_TTL_IN_SECOND.
+func (rg *Registry) Register(tenant string, info types.PeerInfo) error {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+	if time.Since(rg.swept) > PEER_TTL_IN_SECOND*time.Second {
+		for k := range rg.peers {
+			rg.expire(k)
+		}
+		rg.swept = time.Now()
+	}
+	if _, ok := rg.peers[tenant][info.ID]; !ok && len(rg.peers[tenant]) >= MAX_PEERS_PER_TENANT {
+		rg.expire(tenant)
+		if len(rg.peers[tenant]) >= MAX_PEERS_PER_TENANT {
+			return fmt.Errorf("tenant %q has %d online nodes already", tenant, len(rg.peers[tenant]))
+		}
+	}
+	if rg.peers[tenant] == nil {
+		rg.peers[tenant] = make(map[string]types.PeerInfo)
+	}
+	info.LastSeen = time.Now()
+	rg.peers[tenant][info.ID] = info
+	return nil
+}
+
+// expire drops expired nodes of tenant, and tenant once it has none, rg.mu must be held
+func (rg *Registry) expire(tenant string) {
+	for k, v := range rg.peers[tenant] {
+		if time.Since(v.LastSeen) > PEER_TTL_IN_SECOND*time.Second {
+			delete(rg.peers[tenant], k)
+		}
+	}
+	if len(rg.peers[tenant]) == 0 {
+		delete(rg.peers, tenant)
+	}
+}
+
+// List returns online peers of tenant and drops expired ones
+func (rg *Registry) List(tenant string) []types.PeerInfo {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+	rg.expire(tenant)
+	ret := make([]types.PeerInfo, 0, len(rg.peers[tenant]))
+	for _, v := range rg.peers[tenant] {
+		ret = append(ret, v)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+	return ret
+}
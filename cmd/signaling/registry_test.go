@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/suutaku/sshx/pkg/types"
+)
+
+func TestRegistryCap(t *testing.T) {
+	rg := NewRegistry()
+	for i := 0; i < MAX_PEERS_PER_TENANT; i++ {
+		if err := rg.Register("acme", types.PeerInfo{ID: fmt.Sprint(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rg.Register("acme", types.PeerInfo{ID: "new"}); err == nil {
+		t.Error("registered more nodes than MAX_PEERS_PER_TENANT")
+	}
+	// heartbeat of known node and nodes of other tenants are accepted
+	if err := rg.Register("acme", types.PeerInfo{ID: "0"}); err != nil {
+		t.Error(err)
+	}
+	if err := rg.Register("other", types.PeerInfo{ID: "new"}); err != nil {
+		t.Error(err)
+	}
+	// expired nodes make room
+	rg.peers["acme"]["1"] = types.PeerInfo{ID: "1", LastSeen: time.Now().Add(-time.Hour)}
+	if err := rg.Register("acme", types.PeerInfo{ID: "new"}); err != nil {
+		t.Error(err)
+	}
+	if n := len(rg.List("acme")); n != MAX_PEERS_PER_TENANT {
+		t.Errorf("%d nodes, want %d", n, MAX_PEERS_PER_TENANT)
+	}
+}
+
+func TestRegistrySweep(t *testing.T) {
+	rg := NewRegistry()
+	old := time.Now().Add(-time.Hour)
+	rg.peers["gone"] = map[string]types.PeerInfo{"node": {ID: "node", LastSeen: old}}
+	rg.peers["acme"] = map[string]types.PeerInfo{
+		"old":   {ID: "old", LastSeen: old},
+		"fresh": {ID: "fresh", LastSeen: time.Now()},
+	}
+	// not swept before PEER_TTL_IN_SECOND passed
+	rg.Register("other", types.PeerInfo{ID: "node"})
+	if len(rg.peers) != 3 {
+		t.Fatalf("%d tenants, want 3", len(rg.peers))
+	}
+	rg.swept = old
+	rg.Register("other", types.PeerInfo{ID: "node"})
+	if _, ok := rg.peers["gone"]; ok {
+		t.Error("tenant without online nodes kept")
+	}
+	if _, ok := rg.peers["acme"]["old"]; ok {
+		t.Error("expired node kept")
+	}
+	if _, ok := rg.peers["acme"]["fresh"]; !ok {
+		t.Error("online node dropped")
+	}
+}
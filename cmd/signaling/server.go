@@ -1,44 +1,138 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/subtle"
 	"encoding/gob"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
 	"github.com/sirupsen/logrus"
 	"github.com/suutaku/sshx/pkg/types"
 )
 
+const (
+	PING_INTERVAL_IN_SECOND = 5
+	WRITE_WAIT_IN_SECOND    = 10
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+type tenantKey struct{}
+
 type Server struct {
-	port string
-	dm   *DManager
+	port     string
+	dm       *DManager
+	registry *Registry
+	tokens   map[string]string // token to tenant
+	certFile string
+	keyFile  string
+	turn     *TURNIssuer
 }
 
-func NewServer(port string) *Server {
+func NewServer(port string, tokens map[string]string) *Server {
 	return &Server{
-		port: port,
-		dm:   NewDManager(),
+		port:     port,
+		dm:       NewDManager(),
+		registry: NewRegistry(),
+		tokens:   tokens,
 	}
 }
 
+// SetTLS serves https with certificate and key files, plain http if empty
+func (sv *Server) SetTLS(certFile, keyFile string) {
+	sv.certFile = certFile
+	sv.keyFile = keyFile
+}
+
+// SetTURN enables issuing TURN credentials
+func (sv *Server) SetTURN(issuer *TURNIssuer) {
+	sv.turn = issuer
+}
+
 func (sv *Server) Start() {
 
 	r := mux.NewRouter()
-	r.Handle("/pull/{self_id}", sv.pull())
-	r.Handle("/push/{target_id}", sv.push())
+	r.Handle("/pull/{self_id}", sv.auth(sv.pull()))
+	r.Handle("/push/{target_id}", sv.auth(sv.push()))
+	r.Handle("/ws/{self_id}", sv.auth(sv.stream()))
+	r.Handle("/register", sv.auth(sv.register())).Methods(http.MethodPost)
+	r.Handle("/peers", sv.auth(sv.peers())).Methods(http.MethodGet)
+	if sv.turn != nil {
+		r.Handle("/turn", sv.auth(sv.turnCredential())).Methods(http.MethodGet)
+	}
 
 	http.Handle("/", r)
 
+	addr := fmt.Sprintf(":%s", sv.port)
+	if sv.certFile != "" && sv.keyFile != "" {
+		logrus.Infof("Listening on port %s with TLS", sv.port)
+		logrus.Fatal(http.ListenAndServeTLS(addr, sv.certFile, sv.keyFile, nil))
+	}
 	logrus.Infof("Listening on port %s", sv.port)
-	logrus.Fatal(http.ListenAndServe(fmt.Sprintf(":%s", sv.port), nil))
+	logrus.Fatal(http.ListenAndServe(addr, nil))
+}
+
+// auth checks bearer token and puts tenant of token into request context
+func (sv *Server) auth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(sv.tokens) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		for k, tenant := range sv.tokens {
+			if subtle.ConstantTimeCompare([]byte(k), []byte(token)) == 1 {
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantKey{}, tenant)))
+				return
+			}
+		}
+		logrus.Warn("unauthorized request from ", r.RemoteAddr)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	})
+}
+
+// HasTenant reports whether tenant owns a token
+func (sv *Server) HasTenant(tenant string) bool {
+	if len(sv.tokens) == 0 {
+		return true
+	}
+	for _, v := range sv.tokens {
+		if v == tenant {
+			return true
+		}
+	}
+	return false
+}
+
+func tenantOf(r *http.Request) string {
+	tenant, _ := r.Context().Value(tenantKey{}).(string)
+	return tenant
+}
+
+// mailbox id of node, ids of different tenants never meet
+func (sv *Server) mailboxId(r *http.Request, id string) string {
+	tenant := tenantOf(r)
+	if tenant == "" {
+		return id
+	}
+	return tenant + "/" + id
 }
 
 func (sv *Server) pull() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		select {
-		case v := <-sv.dm.Get(vars["self_id"]):
+		case v := <-sv.dm.Get(sv.mailboxId(r, vars["self_id"])):
 			logrus.Debug("pull from ", vars["self_id"], v.Flag)
 			w.Header().Add("Content-Type", "application/binary")
 			if err := gob.NewEncoder(w).Encode(v); err != nil {
@@ -59,7 +153,102 @@ func (sv *Server) push() http.Handler {
 			return
 		}
 		vars := mux.Vars(r)
-		sv.dm.Set(vars["target_id"], info)
+		sv.dm.Set(sv.mailboxId(r, vars["target_id"]), info)
 		logrus.Debug("push from ", info.Source, " to ", vars["target_id"], info.Flag)
 	})
 }
+
+// heartbeat of nodes
+func (sv *Server) register() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var info types.PeerInfo
+		if err := json.NewDecoder(r.Body).Decode(&info); err != nil || info.ID == "" {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		if err := sv.registry.Register(tenantOf(r), info); err != nil {
+			logrus.Warn("refuse heartbeat of ", info.ID, ": ", err)
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		}
+	})
+}
+
+// list online nodes of the same tenant
+func (sv *Server) peers() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(sv.registry.List(tenantOf(r))); err != nil {
+			logrus.Error("json encode failed:", err)
+		}
+	})
+}
+
+// issue a TURN credential, username contains node id given by query "id"
+func (sv *Server) turnCredential() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			id = "sshx"
+		}
+		w.Header().Add("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(sv.turn.Issue(sv.mailboxId(r, id), r.Host)); err != nil {
+			logrus.Error("json encode failed:", err)
+		}
+	})
+}
+
+// stream signaling infos to a websocket client as soon as they were pushed
+func (sv *Server) stream() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			logrus.Error(err)
+			return
+		}
+		defer conn.Close()
+		logrus.Debug("stream to ", vars["self_id"])
+
+		// nothing expected from client, read to handle pong and close
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		ticker := time.NewTicker(PING_INTERVAL_IN_SECOND * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-closed:
+				logrus.Debug("stream of ", vars["self_id"], " closed")
+				return
+			case <-ticker.C:
+				err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(WRITE_WAIT_IN_SECOND*time.Second))
+				if err != nil {
+					logrus.Debug(err)
+					return
+				}
+			case v, ok := <-sv.dm.Keep(sv.mailboxId(r, vars["self_id"])):
+				if !ok {
+					continue
+				}
+				logrus.Debug("stream to ", vars["self_id"], v.Flag)
+				buf := bytes.NewBuffer(nil)
+				if err := gob.NewEncoder(buf).Encode(v); err != nil {
+					logrus.Error("binary encode failed:", err)
+					continue
+				}
+				conn.SetWriteDeadline(time.Now().Add(WRITE_WAIT_IN_SECOND * time.Second))
+				if err := conn.WriteMessage(websocket.BinaryMessage, buf.Bytes()); err != nil {
+					logrus.Debug(err)
+					return
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pion/turn/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/suutaku/sshx/pkg/types"
+)
+
+// TURNIssuer issues TURN credentials which share a secret with TURN server
+// (coturn use-auth-secret/static-auth-secret, or the embedded one)
+type TURNIssuer struct {
+	secret string
+	uris   []string
+	ttl    time.Duration
+	// port of embedded TURN server, uris are derived from request host if not set
+	port int
+}
+
+func NewTURNIssuer(secret string, uris string, ttl time.Duration) *TURNIssuer {
+	ret := &TURNIssuer{
+		secret: secret,
+		ttl:    ttl,
+	}
+	for _, v := range strings.Split(uris, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			ret.uris = append(ret.uris, v)
+		}
+	}
+	return ret
+}
+
+func (ti *TURNIssuer) password(username string) string {
+	mac := hmac.New(sha1.New, []byte(ti.secret))
+	mac.Write([]byte(username))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Issue returns a credential for user valid for ttl, host is the signaling server host
+// requested by node. Username is "expiry:user" and password is base64(hmac-sha1(secret, username))
+func (ti *TURNIssuer) Issue(user, host string) types.TURNCredential {
+	username := fmt.Sprintf("%d:%s", time.Now().Add(ti.ttl).Unix(), user)
+	uris := ti.uris
+	if len(uris) == 0 && ti.port != 0 {
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		addr := net.JoinHostPort(strings.Trim(host, "[]"), strconv.Itoa(ti.port))
+		uris = []string{
+			"turn:" + addr + "?transport=udp",
+			"turn:" + addr + "?transport=tcp",
+		}
+	}
+	return types.TURNCredential{
+		Username: username,
+		Password: ti.password(username),
+		TTL:      int64(ti.ttl / time.Second),
+		URIs:     uris,
+	}
+}
+
+// authHandler checks credentials issued by Issue, validTenant rejects users of removed tenants
+func (ti *TURNIssuer) authHandler(validTenant func(string) bool) turn.AuthHandler {
+	return func(username, realm string, srcAddr net.Addr) ([]byte, bool) {
+		sps := strings.SplitN(username, ":", 2)
+		if len(sps) != 2 {
+			logrus.Warn("invalid TURN username ", username, " from ", srcAddr)
+			return nil, false
+		}
+		expiry, err := strconv.ParseInt(sps[0], 10, 64)
+		if err != nil || expiry < time.Now().Unix() {
+			logrus.Warn("expired TURN username ", username, " from ", srcAddr)
+			return nil, false
+		}
+		tenant := ""
+		if idx := strings.LastIndex(sps[1], "/"); idx >= 0 {
+			tenant = sps[1][:idx]
+		}
+		if !validTenant(tenant) {
+			logrus.Warn("unknown tenant of TURN username ", username, " from ", srcAddr)
+			return nil, false
+		}
+		return turn.GenerateAuthKey(username, realm, ti.password(username)), true
+	}
+}
+
+// StartTURNServer runs an embedded TURN server on UDP and TCP port,
+// relayIP is the address of relays told to nodes, should be public
+func StartTURNServer(issuer *TURNIssuer, port int, realm, relayIP string, validTenant func(string) bool) (*turn.Server, error) {
+	ip := net.ParseIP(relayIP)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid TURN relay ip %q", relayIP)
+	}
+	addr := fmt.Sprintf("0.0.0.0:%d", port)
+	udpListener, err := net.ListenPacket("udp4", addr)
+	if err != nil {
+		return nil, err
+	}
+	tcpListener, err := net.Listen("tcp4", addr)
+	if err != nil {
+		udpListener.Close()
+		return nil, err
+	}
+	server, err := turn.NewServer(turn.ServerConfig{
+		Realm:       realm,
+		AuthHandler: issuer.authHandler(validTenant),
+		PacketConnConfigs: []turn.PacketConnConfig{
+			{
+				PacketConn: udpListener,
+				RelayAddressGenerator: &publicRelayGenerator{
+					RelayAddressGeneratorStatic: turn.RelayAddressGeneratorStatic{
+						RelayAddress: ip,
+						Address:      "0.0.0.0",
+					},
+				},
+			},
+		},
+		ListenerConfigs: []turn.ListenerConfig{
+			{
+				Listener: tcpListener,
+				RelayAddressGenerator: &publicRelayGenerator{
+					RelayAddressGeneratorStatic: turn.RelayAddressGeneratorStatic{
+						RelayAddress: ip,
+						Address:      "0.0.0.0",
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		udpListener.Close()
+		tcpListener.Close()
+		return nil, err
+	}
+	issuer.port = port
+	logrus.Infof("TURN server listening on port %d, relay ip %s", port, relayIP)
+	return server, nil
+}
+
+// publicRelayGenerator allocates relays which only reach public addresses,
+// clients cannot use the server to get into its own or private networks
+type publicRelayGenerator struct {
+	turn.RelayAddressGeneratorStatic
+}
+
+func (g *publicRelayGenerator) AllocatePacketConn(network string, requestedPort int) (net.PacketConn, net.Addr, error) {
+	conn, addr, err := g.RelayAddressGeneratorStatic.AllocatePacketConn(network, requestedPort)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &publicPacketConn{conn, g.RelayAddress}, addr, nil
+}
+
+// publicPacketConn drops packets from and to addresses refused by isRelayablePeer,
+// except relays of this server which peers both behind NAT talk through
+type publicPacketConn struct {
+	net.PacketConn
+	relayIP net.IP
+}
+
+func (c *publicPacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if !c.allowed(addr) {
+		logrus.Debug("drop relayed packet to ", addr)
+		return len(p), nil
+	}
+	return c.PacketConn.WriteTo(p, addr)
+}
+
+func (c *publicPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(p)
+		if err != nil || c.allowed(addr) {
+			return n, addr, err
+		}
+		logrus.Debug("drop relayed packet from ", addr)
+	}
+}
+
+func (c *publicPacketConn) allowed(addr net.Addr) bool {
+	if udpAddr, ok := addr.(*net.UDPAddr); ok && udpAddr.IP.Equal(c.relayIP) {
+		return true
+	}
+	return isRelayablePeer(addr)
+}
+
+// isRelayablePeer refuses loopback, private, link-local (e.g. cloud metadata),
+// multicast and unspecified addresses
+func isRelayablePeer(addr net.Addr) bool {
+	var ip net.IP
+	switch v := addr.(type) {
+	case *net.UDPAddr:
+		ip = v.IP
+	case *net.TCPAddr:
+		ip = v.IP
+	default:
+		return false
+	}
+	return ip != nil && !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsUnspecified() &&
+		!ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() && !ip.IsInterfaceLocalMulticast() && !ip.IsMulticast()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/turn/v2"
+)
+
+func TestTURNAuthHandler(t *testing.T) {
+	const realm = "sshx"
+	issuer := NewTURNIssuer("secret", "", time.Hour)
+	other := NewTURNIssuer("other secret", "", time.Hour)
+	validTenant := func(tenant string) bool { return tenant == "" || tenant == "acme" }
+	auth := issuer.authHandler(validTenant)
+	src := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 3478}
+
+	cred := issuer.Issue("node", "")
+	tenantCred := issuer.Issue("acme/node", "")
+	otherCred := other.Issue("node", "")
+	removedCred := issuer.Issue("gone/node", "")
+	expired := fmt.Sprintf("%d:node", time.Now().Add(-time.Minute).Unix())
+	cases := []struct {
+		name     string
+		username string
+		password string
+		ok       bool
+	}{
+		{"issued", cred.Username, cred.Password, true},
+		{"issued to tenant", tenantCred.Username, tenantCred.Password, true},
+		{"wrong password", cred.Username, "wrong", false},
+		{"issued by other secret", otherCred.Username, otherCred.Password, false},
+		{"expired", expired, issuer.password(expired), false},
+		{"no expiry", "node", issuer.password("node"), false},
+		{"bad expiry", "soon:node", issuer.password("soon:node"), false},
+		{"removed tenant", removedCred.Username, removedCred.Password, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			key, found := auth(c.username, realm, src)
+			// server checks message integrity of client by key
+			ok := found && bytes.Equal(key, turn.GenerateAuthKey(c.username, realm, c.password))
+			if ok != c.ok {
+				t.Errorf("auth of %s = %v, want %v", c.username, ok, c.ok)
+			}
+		})
+	}
+}
+
+func TestTURNIssue(t *testing.T) {
+	issuer := NewTURNIssuer("secret", "", time.Hour)
+	issuer.port = 3478
+	cred := issuer.Issue("node", "signaling.example.com:8990")
+	want := []string{
+		"turn:signaling.example.com:3478?transport=udp",
+		"turn:signaling.example.com:3478?transport=tcp",
+	}
+	if fmt.Sprint(cred.URIs) != fmt.Sprint(want) {
+		t.Errorf("URIs = %v, want %v", cred.URIs, want)
+	}
+	if cred.TTL != int64(time.Hour/time.Second) {
+		t.Errorf("TTL = %d", cred.TTL)
+	}
+	configured := NewTURNIssuer("secret", "turn:a:1, turns:b:2,", time.Hour)
+	configured.port = 3478
+	if uris := configured.Issue("node", "host:8990").URIs; len(uris) != 2 || uris[0] != "turn:a:1" || uris[1] != "turns:b:2" {
+		t.Errorf("configured URIs = %v", uris)
+	}
+}
+
+func TestRelayablePeer(t *testing.T) {
+	relayIP := net.ParseIP("10.0.0.2")
+	conn := &publicPacketConn{relayIP: relayIP}
+	cases := []struct {
+		addr  net.Addr
+		allow bool
+	}{
+		{&net.UDPAddr{IP: net.ParseIP("8.8.8.8"), Port: 53}, true},
+		{&net.UDPAddr{IP: net.ParseIP("2001:4860:4860::8888"), Port: 53}, true},
+		{&net.UDPAddr{IP: relayIP, Port: 50000}, true},
+		{&net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 22}, false},
+		{&net.UDPAddr{IP: net.ParseIP("::1"), Port: 22}, false},
+		{&net.UDPAddr{IP: net.ParseIP("::ffff:127.0.0.1"), Port: 22}, false},
+		{&net.UDPAddr{IP: net.ParseIP("10.0.0.3"), Port: 22}, false},
+		{&net.UDPAddr{IP: net.ParseIP("172.16.1.1"), Port: 22}, false},
+		{&net.UDPAddr{IP: net.ParseIP("192.168.1.1"), Port: 80}, false},
+		{&net.UDPAddr{IP: net.ParseIP("fd00::1"), Port: 80}, false},
+		{&net.UDPAddr{IP: net.ParseIP("169.254.169.254"), Port: 80}, false},
+		{&net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: 80}, false},
+		{&net.UDPAddr{IP: net.ParseIP("0.0.0.0"), Port: 80}, false},
+		{&net.UDPAddr{IP: net.ParseIP("224.0.0.1"), Port: 80}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.addr.String(), func(t *testing.T) {
+			if got := conn.allowed(c.addr); got != c.allow {
+				t.Errorf("allowed(%s) = %v, want %v", c.addr, got, c.allow)
+			}
+		})
+	}
+}
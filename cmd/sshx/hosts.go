@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+
+	cli "github.com/jawher/mow.cli"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/sirupsen/logrus"
+	"github.com/suutaku/sshx/pkg/conf"
+)
+
+func cmdHostsAdd(cmd *cli.Cmd) {
+	cmd.Spec = "[ -u ] [ -i ] ALIAS ID"
+	userName := cmd.StringOpt("u user", "", "default login user of host")
+	ident := cmd.StringOpt("i identification", "", "default private key path of host")
+	alias := cmd.StringArg("ALIAS", "", "alias of host")
+	id := cmd.StringArg("ID", "", "node id of host")
+	cmd.Action = func() {
+		cm := conf.NewConfManager(getRootPath())
+		err := cm.AddHost(conf.HostEntry{
+			Alias:    *alias,
+			ID:       *id,
+			User:     *userName,
+			Identity: *ident,
+		})
+		if err != nil {
+			logrus.Error(err)
+		}
+	}
+}
+
+func cmdHostsRemove(cmd *cli.Cmd) {
+	cmd.Spec = "ALIAS"
+	alias := cmd.StringArg("ALIAS", "", "alias of host")
+	cmd.Action = func() {
+		cm := conf.NewConfManager(getRootPath())
+		err := cm.RemoveHost(*alias)
+		if err != nil {
+			logrus.Error(err)
+		}
+	}
+}
+
+func cmdHostsList(cmd *cli.Cmd) {
+	cmd.Action = func() {
+		cm := conf.NewConfManager(getRootPath())
+		t := table.NewWriter()
+		t.SetOutputMirror(os.Stdout)
+		t.AppendHeader(table.Row{"Alias", "ID", "User", "Identity"})
+		t.AppendSeparator()
+		for _, v := range cm.Conf().Hosts {
+			t.AppendRow(table.Row{v.Alias, v.ID, v.User, v.Identity})
+		}
+		t.Render()
+	}
+}
+
+func cmdHosts(cmd *cli.Cmd) {
+	cmd.Command("add", "add or update a host alias", cmdHostsAdd)
+	cmd.Command("rm", "remove a host alias", cmdHostsRemove)
+	cmd.Command("ls", "list host aliases", cmdHostsList)
+}
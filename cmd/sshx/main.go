@@ -20,6 +20,7 @@ func main() {
 	app.Command("daemon", "launch a sshx daemon", cmdDaemon)
 	app.Command("conf", "list configure informations", cmdConfig)
 	app.Command("conn", "connect to remote host", cmdConnect)
+	app.Command("exec", "run a command on remote host", cmdExec)
 	app.Command("cpyid", "copy public key to server", cmdCopyId)
 	app.Command("scp", "copy files or directory from/to remote host", cmdCopy)
 	app.Command("proxy", "start proxy", cmdProxy)
@@ -28,6 +29,9 @@ func main() {
 	app.Command("vnc", "vnc service", cmdVNCService)
 	app.Command("msg", "a message console", cmdMessage)
 	app.Command("trans", "transfer a file", cmdTransfer)
+	app.Command("trust", "manage peers allowed to connect", cmdTrust)
+	app.Command("peers", "list online devices", cmdPeers)
+	app.Command("hosts", "manage host aliases", cmdHosts)
 	app.Run(os.Args)
 
 }
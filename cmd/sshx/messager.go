@@ -4,7 +4,7 @@ import (
 	"fmt"
 
 	cli "github.com/jawher/mow.cli"
-	"github.com/sirupsen/logrus"
+	"github.com/suutaku/sshx/pkg/conf"
 	"github.com/suutaku/sshx/pkg/impl"
 	"github.com/suutaku/sshx/pkg/types"
 )
@@ -18,13 +18,14 @@ func cmdStartMessage(cmd *cli.Cmd) {
 			fmt.Println("please set a remote device")
 		}
 
-		msgr := impl.NewMessager(*addr)
+		cm := conf.NewConfManager(getRootPath())
+		msgr := impl.NewMessager(cm.Conf().ResolveHost(*addr))
 		msgr.Preper()
 
 		sender := impl.NewSender(msgr, types.OPTION_TYPE_UP)
 		conn, err := sender.Send()
 		if err != nil {
-			logrus.Error("cannot create messager with ", *addr, ":", err)
+			showError("cannot create messager with "+*addr, err)
 			return
 		}
 		msgr.OpenChatConsole(conn)
@@ -48,7 +49,7 @@ func cmdAttachMessage(cmd *cli.Cmd) {
 		sender.PairId = []byte(*pairId)
 		conn, err := sender.Send()
 		if err != nil {
-			logrus.Error("cannot attach messager with ", *pairId, ":", err)
+			showError("cannot attach messager "+*pairId, err)
 			return
 		}
 		updateMsgr := sender.GetImpl().(*impl.Messager)
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	cli "github.com/jawher/mow.cli"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/sirupsen/logrus"
+	"github.com/suutaku/sshx/internal/signaling"
+	"github.com/suutaku/sshx/pkg/conf"
+	"github.com/suutaku/sshx/pkg/impl"
+)
+
+func cmdPeers(cmd *cli.Cmd) {
+	cmd.Action = func() {
+		cm := conf.NewConfManager(getRootPath())
+		peers, err := signaling.NewClient(cm).Peers()
+		if err != nil {
+			logrus.Error(err)
+			return
+		}
+		t := table.NewWriter()
+		t.SetOutputMirror(os.Stdout)
+		t.AppendHeader(table.Row{"#", "ID", "Name", "Version", "Applications", "Last Seen"})
+		t.AppendSeparator()
+		for k, v := range peers {
+			if v.ID == cm.Conf().ID {
+				v.Name += " (self)"
+			}
+			names := make([]string, 0, len(v.Impls))
+			for _, code := range v.Impls {
+				if impl.GetImpl(code) != nil {
+					names = append(names, strings.TrimPrefix(impl.GetImplName(code), "*"))
+				}
+			}
+			t.AppendRows([]table.Row{
+				{k + 1, v.ID, v.Name, v.Version, strings.Join(names, ","), time.Since(v.LastSeen).Round(time.Second).String() + " ago"},
+			})
+		}
+		t.AppendSeparator()
+		t.Render()
+	}
+}
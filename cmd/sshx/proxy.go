@@ -5,6 +5,7 @@ import (
 
 	cli "github.com/jawher/mow.cli"
 	"github.com/sirupsen/logrus"
+	"github.com/suutaku/sshx/pkg/conf"
 	"github.com/suutaku/sshx/pkg/impl"
 	"github.com/suutaku/sshx/pkg/types"
 )
@@ -17,7 +18,12 @@ func cmdStopProxy(cmd *cli.Cmd) {
 		imp.NoNeedConnect()
 		sender := impl.NewSender(imp, types.OPTION_TYPE_DOWN)
 		sender.PairId = []byte(*pairId)
-		sender.SendDetach()
+		conn, err := sender.SendDetach()
+		if err != nil {
+			showError("cannot stop proxy "+*pairId, err)
+			return
+		}
+		conn.Close()
 	}
 }
 
@@ -35,14 +41,17 @@ func cmdStartProxy(cmd *cli.Cmd) {
 			fmt.Println("please set a remote device")
 		}
 
-		proxy := impl.NewProxy(int32(*proxyPort), *addr)
+		cm := conf.NewConfManager(getRootPath())
+		proxy := impl.NewProxy(int32(*proxyPort), cm.Conf().ResolveHost(*addr))
 		proxy.Preper()
 		proxy.NoNeedConnect()
 
 		sender := impl.NewSender(proxy, types.OPTION_TYPE_UP)
 		_, err := sender.SendDetach()
 		if err != nil {
-			logrus.Error(err)
+			showError("cannot start proxy to "+proxy.HostId(), err)
+			proxy.Close()
+			return
 		}
 		err = proxy.Start()
 		if err != nil {
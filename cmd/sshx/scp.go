@@ -28,7 +28,7 @@ func cmdCopy(cmd *cli.Cmd) {
 		sender := impl.NewSender(imp, types.OPTION_TYPE_UP)
 		_, err = sender.Send()
 		if err != nil {
-			logrus.Error(err)
+			showError("cannot copy", err)
 			return
 		}
 
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"strings"
+
 	cli "github.com/jawher/mow.cli"
 	"github.com/sirupsen/logrus"
 	"github.com/suutaku/sshx/pkg/impl"
@@ -23,7 +25,7 @@ func cmdCopyId(cmd *cli.Cmd) {
 		sender := impl.NewSender(imp, types.OPTION_TYPE_UP)
 		conn, err := sender.Send()
 		if err != nil {
-			logrus.Error(err)
+			showError("cannot connect to "+imp.HostId(), err)
 			return
 		}
 		imp.OpenTerminal(conn)
@@ -31,9 +33,10 @@ func cmdCopyId(cmd *cli.Cmd) {
 }
 
 func cmdConnect(cmd *cli.Cmd) {
-	cmd.Spec = "[ -X ] [ -i ]ADDR"
+	cmd.Spec = "[ -X ] [ -A ] [ -i ]ADDR"
 
 	tmp := cmd.BoolOpt("X x11", false, "using X11 opton, default false")
+	forwardAgent := cmd.BoolOpt("A agent", false, "forward ssh-agent to remote host, default false")
 	ident := cmd.StringOpt("i identification", "", "a private path, default empty for ~/.ssh/id_rsa")
 
 	addr := cmd.StringArg("ADDR", "", "remote target address [username]@[host]:[port]")
@@ -42,6 +45,7 @@ func cmdConnect(cmd *cli.Cmd) {
 			return
 		}
 		imp := impl.NewSSH(*addr, *tmp, *ident, false)
+		imp.ForwardAgent = *forwardAgent
 		err := imp.Preper()
 		if err != nil {
 			logrus.Error(err)
@@ -50,9 +54,47 @@ func cmdConnect(cmd *cli.Cmd) {
 		sender := impl.NewSender(imp, types.OPTION_TYPE_UP)
 		conn, err := sender.Send()
 		if err != nil {
-			logrus.Error(err)
+			showError("cannot connect to "+imp.HostId(), err)
 			return
 		}
 		imp.OpenTerminal(conn)
 	}
 }
+
+// exit code of sshx exec if command did not run, the same as ssh
+const execFailed = 255
+
+func cmdExec(cmd *cli.Cmd) {
+	cmd.Spec = "[ -t ] [ -A ] [ -i ] ADDR CMD..."
+
+	tty := cmd.BoolOpt("t tty", false, "request a pty for interactive commands, default false")
+	forwardAgent := cmd.BoolOpt("A agent", false, "forward ssh-agent to remote host, default false")
+	ident := cmd.StringOpt("i identification", "", "a private path, default empty for ~/.ssh/id_rsa")
+
+	addr := cmd.StringArg("ADDR", "", "remote target address [username]@[host]:[port]")
+	command := cmd.StringsArg("CMD", nil, "command to run on remote host, after -- if it has options")
+	cmd.Action = func() {
+		imp := impl.NewSSH(*addr, false, *ident, false)
+		imp.Command = strings.Join(*command, " ")
+		imp.Tty = *tty
+		imp.ForwardAgent = *forwardAgent
+		err := imp.Preper()
+		if err != nil {
+			logrus.Error(err)
+			cli.Exit(execFailed)
+		}
+		sender := impl.NewSender(imp, types.OPTION_TYPE_UP)
+		conn, err := sender.Send()
+		if err != nil {
+			showError("cannot connect to "+imp.HostId(), err)
+			cli.Exit(execFailed)
+		}
+		defer conn.Close()
+		status, err := imp.Exec(conn)
+		if err != nil {
+			logrus.Error(err)
+			cli.Exit(execFailed)
+		}
+		cli.Exit(status)
+	}
+}
@@ -23,7 +23,12 @@ func cmdUnmount(cmd *cli.Cmd) {
 		}
 		sender := impl.NewSender(&impl.SSHFS{}, types.OPTION_TYPE_DOWN)
 		sender.PairId = []byte(*pidOpt)
-		sender.SendDetach()
+		conn, err := sender.SendDetach()
+		if err != nil {
+			showError("cannot unmount "+*pidOpt, err)
+			return
+		}
+		conn.Close()
 	}
 }
 
@@ -57,7 +62,7 @@ func cmdMount(cmd *cli.Cmd) {
 		sender := impl.NewSender(imp, types.OPTION_TYPE_UP)
 		_, err = sender.SendDetach()
 		if err != nil {
-			logrus.Error(err)
+			showError("cannot mount "+root, err)
 			return
 		}
 		logrus.Infof("Mount %s %s to %s\n", imp.HostId(), root, mtp)
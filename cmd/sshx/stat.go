@@ -26,7 +26,7 @@ func cmdStatus(cmd *cli.Cmd) {
 		}
 		conn, err := sender.Send()
 		if err != nil {
-			logrus.Error(err)
+			showError("cannot get status", err)
 			return
 		}
 		imp.SetConn(conn)
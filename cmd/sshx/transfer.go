@@ -3,13 +3,14 @@ package main
 import (
 	cli "github.com/jawher/mow.cli"
 	"github.com/sirupsen/logrus"
+	"github.com/suutaku/sshx/pkg/conf"
 	"github.com/suutaku/sshx/pkg/impl"
 	"github.com/suutaku/sshx/pkg/types"
 )
 
 func cmdUpload(cmd *cli.Cmd) {
 	cmd.Spec = "[-t] [-f] [-q]"
-	hostId := cmd.StringOpt("t target", "", "target device id")
+	hostId := cmd.StringOpt("t target", "", "target device id or alias")
 	filePath := cmd.StringOpt("f file", "", "path of file to upload")
 	showQR := cmd.BoolOpt("q qrcode", false, "show QR code (upload from or download to mobile device)")
 	cmd.Action = func() {
@@ -17,6 +18,7 @@ func cmdUpload(cmd *cli.Cmd) {
 		if hostId == nil || *hostId == "" {
 			*hostId = "127.0.0.1"
 		}
+		*hostId = conf.NewConfManager(getRootPath()).Conf().ResolveHost(*hostId)
 
 		imp := impl.NewTransferService(*hostId, *filePath, true, *showQR)
 		imp.Init()
@@ -29,7 +31,7 @@ func cmdUpload(cmd *cli.Cmd) {
 		sender := impl.NewSender(imp, types.OPTION_TYPE_UP)
 		conn, err := sender.SendDetach()
 		if err != nil {
-			logrus.Error(err)
+			showError("cannot upload to "+*hostId, err)
 			return
 		}
 		imp.PId = string(sender.PairId)
@@ -44,13 +46,14 @@ func cmdUpload(cmd *cli.Cmd) {
 }
 func cmdDownload(cmd *cli.Cmd) {
 	cmd.Spec = "[-t] [-f] [-q]"
-	hostId := cmd.StringOpt("t target", "", "target device id")
+	hostId := cmd.StringOpt("t target", "", "target device id or alias")
 	filePath := cmd.StringOpt("f file", "", "path of file to download")
 	showQR := cmd.BoolOpt("q qrcode", false, "show QR code (upload from or download to mobile device)")
 	cmd.Action = func() {
 		if hostId == nil || *hostId == "" {
 			*hostId = "127.0.0.1"
 		}
+		*hostId = conf.NewConfManager(getRootPath()).Conf().ResolveHost(*hostId)
 
 		imp := impl.NewTransferService(*hostId, *filePath, false, *showQR)
 		if imp == nil {
@@ -67,7 +70,7 @@ func cmdDownload(cmd *cli.Cmd) {
 		sender := impl.NewSender(imp, types.OPTION_TYPE_UP)
 		conn, err := sender.SendDetach()
 		if err != nil {
-			logrus.Error(err)
+			showError("cannot download from "+*hostId, err)
 			return
 		}
 		imp.PId = string(sender.PairId)
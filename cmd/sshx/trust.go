@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+
+	cli "github.com/jawher/mow.cli"
+	"github.com/sirupsen/logrus"
+	"github.com/suutaku/sshx/pkg/conf"
+)
+
+func cmdTrustKey(cmd *cli.Cmd) {
+	cmd.Action = func() {
+		cm := conf.NewConfManager(getRootPath())
+		identity, err := cm.Identity()
+		if err != nil {
+			logrus.Error(err)
+			return
+		}
+		fmt.Printf("%s %s\n", cm.Conf().ID, identity.PublicKey())
+	}
+}
+
+func cmdTrustAdd(cmd *cli.Cmd) {
+	cmd.Spec = "ID KEY"
+	id := cmd.StringArg("ID", "", "node id of peer")
+	key := cmd.StringArg("KEY", "", "public key of peer, get it by running 'sshx trust key' on peer")
+	cmd.Action = func() {
+		if *id == "" || *key == "" {
+			return
+		}
+		if _, err := conf.ParsePublicKey(*key); err != nil {
+			logrus.Error("invalid public key: ", err)
+			return
+		}
+		cm := conf.NewConfManager(getRootPath())
+		err := cm.AddTrustedPeer(*id, *key)
+		if err != nil {
+			logrus.Error(err)
+		}
+	}
+}
+
+func cmdTrustRemove(cmd *cli.Cmd) {
+	cmd.Spec = "ID"
+	id := cmd.StringArg("ID", "", "node id of peer")
+	cmd.Action = func() {
+		cm := conf.NewConfManager(getRootPath())
+		err := cm.RemoveTrustedPeer(*id)
+		if err != nil {
+			logrus.Error(err)
+		}
+	}
+}
+
+func cmdTrustList(cmd *cli.Cmd) {
+	cmd.Action = func() {
+		cm := conf.NewConfManager(getRootPath())
+		for _, v := range cm.Conf().TrustedPeers {
+			fmt.Printf("%s %s\n", v.ID, v.PublicKey)
+		}
+	}
+}
+
+func cmdTrust(cmd *cli.Cmd) {
+	cmd.Command("key", "show id and public key of this device", cmdTrustKey)
+	cmd.Command("add", "trust signaling messages from a peer", cmdTrustAdd)
+	cmd.Command("rm", "remove a trusted peer", cmdTrustRemove)
+	cmd.Command("ls", "list trusted peers", cmdTrustList)
+}
@@ -1,12 +1,26 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"os"
+	"syscall"
 
 	"github.com/sirupsen/logrus"
+	"github.com/suutaku/sshx/pkg/types"
 )
 
+// what user can do about failures reported by daemon
+var statusHints = map[int32]string{
+	types.STATUS_PEER_OFFLINE: "make sure the device is online and its sshx daemon is running",
+	types.STATUS_ACL_DENIED:   "the device does not allow it, check ACL and trusted keys of its configure",
+	types.STATUS_IMPL_UNKNOWN: "sshx of the device does not support this application, upgrade it",
+	types.STATUS_ICE_FAILED:   "no network path to the device, a TURN server of RTCConf may help",
+	types.STATUS_TIMEOUT:      "the device answered too slowly, try again",
+	types.STATUS_REMOTE_ERROR: "the application failed on the device, see log of its daemon",
+	types.STATUS_NOT_FOUND:    "list running sessions with sshx stat",
+}
+
 func getRootPath() string {
 	rootStr := os.Getenv("SSHX_HOME")
 	if rootStr == "" {
@@ -20,3 +34,23 @@ func getRootPath() string {
 	}
 	return rootStr
 }
+
+// showError logs a failed request to daemon with a hint
+func showError(action string, err error) {
+	var e *types.Error
+	switch {
+	case errors.As(err, &e):
+		logrus.Errorf("%s: %v", action, e)
+		if hint := statusHints[e.Code]; hint != "" {
+			logrus.Info(hint)
+		}
+	case errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ENOENT):
+		logrus.Errorf("%s: sshx daemon is not running, start it with sshx daemon", action)
+	case errors.Is(err, context.DeadlineExceeded):
+		logrus.Errorf("%s: no response from sshx daemon", action)
+	case errors.Is(err, context.Canceled):
+		logrus.Errorf("%s: canceled", action)
+	default:
+		logrus.Errorf("%s: %v", action, err)
+	}
+}
@@ -22,7 +22,7 @@ func cmdStartVNCService(cmd *cli.Cmd) {
 		}
 		_, err = sender.SendDetach()
 		if err != nil {
-			logrus.Error(err)
+			showError("cannot start vnc service", err)
 			return
 		}
 	}
@@ -37,7 +37,12 @@ func cmdStopVNCService(cmd *cli.Cmd) {
 		}
 		sender := impl.NewSender(&impl.VNCService{}, types.OPTION_TYPE_DOWN)
 		sender.PairId = []byte(*pidOpt)
-		sender.SendDetach()
+		conn, err := sender.SendDetach()
+		if err != nil {
+			showError("cannot stop vnc service "+*pidOpt, err)
+			return
+		}
+		conn.Close()
 	}
 }
 
@@ -1,6 +1,7 @@
 package conn
 
 import (
+	"context"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -19,12 +20,14 @@ type Connection interface {
 	PoolId() *types.PoolId
 	ResetPoolId(id types.PoolId)
 	TargetId() string
-	Dial() error
+	// dial remote, ctx limits connection setup only
+	Dial(ctx context.Context) error
 	Response() error
 	Direction() int32
 	IsReady() bool
 	Ready()
 	Name() string
+	Transport() string
 }
 
 type BaseConnection struct {
@@ -1,8 +1,9 @@
 package conn
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/gob"
-	"fmt"
 	"net"
 	"reflect"
 
@@ -16,19 +17,26 @@ type DirectConnection struct {
 	BaseConnection
 	net.Conn
 	CleanChan *chan CleanRequest
+	// dialer only
+	tlsConf *tls.Config
+	addr    string
 }
 
-func NewDirectConnection(impl impl.Impl, nodeId string, targetId string, poolId types.PoolId, direct int32, cleanChan *chan CleanRequest) *DirectConnection {
+func NewDirectConnection(impl impl.Impl, nodeId string, targetId string, poolId types.PoolId, direct int32, cleanChan *chan CleanRequest, tlsConf *tls.Config, addr string) *DirectConnection {
 	ret := &DirectConnection{
 		BaseConnection: *NewBaseConnection(impl, nodeId, targetId, poolId, direct, impl.Code()),
 		CleanChan:      cleanChan,
+		tlsConf:        tlsConf,
+		addr:           addr,
 	}
 	return ret
 }
 
 func (dc *DirectConnection) Close() {
 	dc.BaseConnection.Close()
-	dc.Conn.Close()
+	if dc.Conn != nil {
+		dc.Conn.Close()
+	}
 }
 
 func (dc *DirectConnection) Name() string {
@@ -39,20 +47,28 @@ func (dc *DirectConnection) Name() string {
 	}
 }
 
-func (dc *DirectConnection) Dial() error {
+func (dc *DirectConnection) Transport() string {
+	return "direct"
+}
+
+func (dc *DirectConnection) Dial(ctx context.Context) error {
 	if dc.impl.IsNeedConnect() {
-		logrus.Debug("dial ", dc.TargetId(), " directly")
-		conn, err := net.Dial("tcp", fmt.Sprintf("%s:%d", dc.TargetId(), directPort))
+		logrus.Debug("dial ", dc.TargetId(), " directly at ", dc.addr)
+		dialer := tls.Dialer{Config: dc.tlsConf}
+		conn, err := dialer.DialContext(ctx, "tcp", dc.addr)
 		if err != nil {
-			return err
+			return dialError(ctx, dc.TargetId(), dc.addr, err)
 		}
 		info := DirectInfo{
 			ImplCode: dc.impl.Code(),
 			HostId:   dc.nodeId,
 			Id:       dc.poolId.Raw(),
 		}
-		logrus.Debug("send direct info")
-		gob.NewEncoder(conn).Encode(info)
+		err = requestDirect(ctx, conn, info, dc.TargetId())
+		if err != nil {
+			conn.Close()
+			return err
+		}
 		implConn := dc.impl.Conn()
 		dc.Conn = conn
 		go func() {
@@ -75,6 +91,11 @@ func (dc *DirectConnection) Dial() error {
 func (dc *DirectConnection) Response() error {
 	dc.Ready()
 	err := dc.BaseConnection.Response()
+	if err != nil {
+		rejectDirect(dc.Conn, types.NewError(types.STATUS_REMOTE_ERROR, "%v", err))
+		return err
+	}
+	err = gob.NewEncoder(dc.Conn).Encode(DirectReply{Accepted: true})
 	if err != nil {
 		return err
 	}
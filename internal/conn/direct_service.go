@@ -1,38 +1,58 @@
 package conn
 
 import (
-	"encoding/gob"
+	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
 
 	"github.com/sirupsen/logrus"
+	"github.com/suutaku/sshx/internal/discovery"
+	"github.com/suutaku/sshx/pkg/conf"
 	"github.com/suutaku/sshx/pkg/impl"
 	"github.com/suutaku/sshx/pkg/types"
 )
 
-const directPort = 8099
-
 type DirectInfo struct {
 	Id       int64
 	ImplCode int32
 	HostId   string
 }
 
+// DirectReply answers a DirectInfo before any impl data
+type DirectReply struct {
+	Accepted bool
+	Code     int32 // status code of reject
+	Message  string
+}
+
+// DirectService connects nodes over TLS, both sides authenticate by their identity keys
 type DirectService struct {
 	BaseConnectionService
+	*lanBase
+	advertiser *discovery.Advertiser
 }
 
-func NewDirectService(id string) *DirectService {
+func NewDirectService(cm *conf.ConfManager) *DirectService {
 	return &DirectService{
-		BaseConnectionService: *NewBaseConnectionService(id),
+		BaseConnectionService: *NewBaseConnectionService(cm.Conf().ID),
+		lanBase:               newLanBase(cm),
 	}
 }
 
 func (ds *DirectService) Start() error {
-	ds.BaseConnectionService.Start()
-	listenner, err := net.Listen("tcp", fmt.Sprintf(":%d", directPort))
+	if !ds.hasCertificate() {
+		return fmt.Errorf("direct service disabled without node identity")
+	}
+	listenner, err := tls.Listen("tcp", fmt.Sprintf(":%d", ds.port()), ds.tlsConfig(""))
 	if err != nil {
 		logrus.Error(err)
+		return err
+	}
+	ds.BaseConnectionService.Start()
+	ds.advertiser = discovery.NewAdvertiser(ds.Id(), ds.confManager.Conf().DeviceName(), int(ds.port()))
+	if err := ds.advertiser.Start(); err != nil {
+		logrus.Warn("cannot advertise on LAN: ", err)
 	}
 
 	go func() {
@@ -43,33 +63,52 @@ func (ds *DirectService) Start() error {
 				logrus.Error(err)
 				continue
 			}
-			var info DirectInfo
-			err = gob.NewDecoder(sock).Decode(&info)
-			if err != nil {
-				logrus.Error(err)
-				continue
-			}
-			logrus.Debug("new direct info com ", info)
-			imp := impl.GetImpl(info.ImplCode)
-			imp.SetHostId(info.HostId)
-			poolId := types.NewPoolId(info.Id, imp.Code())
-			// server reset direction
-			conn := NewDirectConnection(imp, ds.Id(), info.HostId, *poolId, CONNECTION_DRECT_IN, &ds.CleanChan)
-			conn.Conn = sock
-			err = conn.Response()
-			if err != nil {
-				logrus.Error(err)
-				continue
-			}
-			ds.AddPair(conn)
+			go ds.serve(sock.(*tls.Conn))
 		}
 	}()
 	return nil
 }
 
-func (ds *DirectService) CreateConnection(sender *impl.Sender, sock net.Conn, poolId types.PoolId) error {
+func (ds *DirectService) Stop() {
+	if ds.advertiser != nil {
+		ds.advertiser.Stop()
+	}
+	ds.BaseConnectionService.Stop()
+}
+
+func (ds *DirectService) serve(sock *tls.Conn) {
+	err := sock.Handshake()
+	if err != nil {
+		logrus.Warn("direct handshake with ", sock.RemoteAddr(), " failed: ", err)
+		sock.Close()
+		return
+	}
+	peerId, err := ds.peerId(sock.ConnectionState().PeerCertificates[0])
+	if err != nil {
+		logrus.Error(err)
+		sock.Close()
+		return
+	}
+	imp, info, err := ds.acceptInfo(sock, peerId)
+	if err != nil {
+		logrus.Warn(err)
+		return
+	}
+	poolId := types.NewPoolId(info.Id, imp.Code())
+	// server reset direction
+	conn := NewDirectConnection(imp, ds.Id(), info.HostId, *poolId, CONNECTION_DRECT_IN, &ds.CleanChan, nil, "")
+	conn.Conn = sock
+	err = conn.Response()
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	ds.AddPair(conn)
+}
+
+func (ds *DirectService) CreateConnection(ctx context.Context, sender *impl.Sender, sock net.Conn, poolId types.PoolId) error {
 	// client reset direction
-	err := ds.BaseConnectionService.CreateConnection(sender, sock, poolId)
+	err := ds.BaseConnectionService.CreateConnection(ctx, sender, sock, poolId)
 	if err != nil {
 		return err
 	}
@@ -82,8 +121,15 @@ func (ds *DirectService) CreateConnection(sender *impl.Sender, sock net.Conn, po
 	if !sender.Detach {
 		iface.SetConn(sock)
 	}
-	pair := NewDirectConnection(iface, ds.Id(), iface.HostId(), poolId, CONNECTION_DRECT_OUT, &ds.CleanChan)
-	err = pair.Dial()
+	addr := ""
+	if iface.IsNeedConnect() {
+		addr, err = ds.dialAddress(iface.HostId())
+		if err != nil {
+			return err
+		}
+	}
+	pair := NewDirectConnection(iface, ds.Id(), iface.HostId(), poolId, CONNECTION_DRECT_OUT, &ds.CleanChan, ds.tlsConfig(iface.HostId()), addr)
+	err = pair.Dial(ctx)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,215 @@
+package conn
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/sirupsen/logrus"
+	"github.com/suutaku/sshx/internal/discovery"
+	"github.com/suutaku/sshx/internal/utils"
+	"github.com/suutaku/sshx/pkg/conf"
+	"github.com/suutaku/sshx/pkg/impl"
+	"github.com/suutaku/sshx/pkg/types"
+)
+
+// used if DirectPort not configured
+const defaultDirectPort = 8099
+
+const (
+	// wait for LAN answer, other services keep racing meanwhile
+	discoveryTimeout = time.Second
+	// reuse addresses found on LAN for a while
+	discoveryCacheTTL = time.Minute
+)
+
+type discovered struct {
+	addr    string
+	expires time.Time
+}
+
+// lanBase is shared by services dialing nodes on LAN or forwarded ports,
+// both sides authenticate by their identity keys
+type lanBase struct {
+	confManager *conf.ConfManager
+	cert        tls.Certificate
+	found       map[string]discovered
+	foundLock   sync.Mutex
+}
+
+func newLanBase(cm *conf.ConfManager) *lanBase {
+	ret := &lanBase{
+		confManager: cm,
+		found:       make(map[string]discovered),
+	}
+	identity, err := cm.Identity()
+	if err != nil {
+		logrus.Error("cannot load node identity: ", err)
+		return ret
+	}
+	ret.cert, err = identity.Certificate(cm.Conf().ID)
+	if err != nil {
+		logrus.Error("cannot create node certificate: ", err)
+	}
+	return ret
+}
+
+func (lb *lanBase) hasCertificate() bool {
+	return len(lb.cert.Certificate) > 0
+}
+
+func (lb *lanBase) port() int32 {
+	if lb.confManager.Conf().DirectPort > 0 {
+		return lb.confManager.Conf().DirectPort
+	}
+	return defaultDirectPort
+}
+
+// peerId returns the trusted node owning key of certificate
+func (lb *lanBase) peerId(cert *x509.Certificate) (string, error) {
+	key, ok := cert.PublicKey.(ed25519.PublicKey)
+	if !ok {
+		return "", fmt.Errorf("certificate key of %s is not an ed25519 key", cert.Subject.CommonName)
+	}
+	id := lb.confManager.Conf().TrustedPeerId(base64.StdEncoding.EncodeToString(key))
+	if id == "" {
+		return "", fmt.Errorf("untrusted node %s", cert.Subject.CommonName)
+	}
+	return id, nil
+}
+
+// tlsConfig accepts certificates of trusted peers, the peer must be target if target is trusted.
+// Chains are not verified, the handshake proves peer owns the key. Checked on resumed sessions too.
+func (lb *lanBase) tlsConfig(target string) *tls.Config {
+	return &tls.Config{
+		Certificates:       []tls.Certificate{lb.cert},
+		ClientAuth:         tls.RequireAnyClientCert,
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS13,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return fmt.Errorf("no certificate from peer")
+			}
+			id, err := lb.peerId(cs.PeerCertificates[0])
+			if err != nil {
+				return err
+			}
+			if target != "" && lb.confManager.Conf().TrustedKey(target) != "" && id != target {
+				return fmt.Errorf("expect node %s, got %s", target, id)
+			}
+			return nil
+		},
+	}
+}
+
+// dialAddress finds LAN address of trusted node ids by mDNS, other hosts are dialed as is
+func (lb *lanBase) dialAddress(target string) (string, error) {
+	if lb.confManager.Conf().TrustedKey(target) == "" {
+		return net.JoinHostPort(target, strconv.Itoa(int(lb.port()))), nil
+	}
+	lb.foundLock.Lock()
+	cached, ok := lb.found[target]
+	lb.foundLock.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.addr, nil
+	}
+	rec, err := discovery.Resolve(target, discoveryTimeout)
+	if err != nil {
+		return "", types.NewError(types.STATUS_PEER_OFFLINE, "%s not found on local network: %v", target, err)
+	}
+	logrus.Debug("found ", target, " (", rec.Name, ") at ", rec.Addr())
+	lb.foundLock.Lock()
+	lb.found[target] = discovered{rec.Addr(), time.Now().Add(discoveryCacheTTL)}
+	lb.foundLock.Unlock()
+	return rec.Addr(), nil
+}
+
+// dialError marks err of dialing addr as peer offline if nothing answered there
+func dialError(ctx context.Context, target, addr string, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	var opErr *net.OpError
+	var idleErr *quic.IdleTimeoutError
+	var handshakeErr *quic.HandshakeTimeoutError
+	if (errors.As(err, &opErr) && opErr.Op == "dial") || errors.As(err, &idleErr) ||
+		errors.As(err, &handshakeErr) || errors.Is(err, context.DeadlineExceeded) {
+		return types.NewError(types.STATUS_PEER_OFFLINE, "cannot reach %s at %s: %v", target, addr, err)
+	}
+	return err
+}
+
+// acceptInfo reads DirectInfo from authenticated peer and returns impl it asked for,
+// sock is rejected and closed on error
+func (lb *lanBase) acceptInfo(sock net.Conn, peerId string) (impl.Impl, *DirectInfo, error) {
+	var info DirectInfo
+	err := gob.NewDecoder(sock).Decode(&info)
+	if err != nil {
+		sock.Close()
+		return nil, nil, err
+	}
+	logrus.Debug("new direct info com ", info)
+	if info.HostId != peerId {
+		rejectDirect(sock, types.NewError(types.STATUS_ACL_DENIED, "host id %s mismatch", info.HostId))
+		return nil, nil, fmt.Errorf("direct info claims %s but peer is %s", info.HostId, peerId)
+	}
+	imp := impl.GetImpl(info.ImplCode)
+	if imp == nil {
+		rejectDirect(sock, types.NewError(types.STATUS_IMPL_UNKNOWN, "unknown impl code %d", info.ImplCode))
+		return nil, nil, fmt.Errorf("unknow impl for IMCODE: %d", info.ImplCode)
+	}
+	if !lb.confManager.Conf().ACL.IsAllowed(info.HostId, imp.Code()) {
+		rejectDirect(sock, types.NewError(types.STATUS_ACL_DENIED, "%s not allowed", impl.GetImplName(imp.Code())))
+		return nil, nil, fmt.Errorf("ACL denied %s from %s", impl.GetImplName(imp.Code()), info.HostId)
+	}
+	imp.SetHostId(info.HostId)
+	return imp, &info, nil
+}
+
+// requestDirect sends DirectInfo to responser and waits for its reply, conn is closed if ctx done
+func requestDirect(ctx context.Context, conn net.Conn, info DirectInfo, target string) error {
+	stop := utils.CloseOnDone(ctx, conn)
+	defer stop()
+	logrus.Debug("send direct info")
+	err := gob.NewEncoder(conn).Encode(info)
+	if err != nil {
+		return err
+	}
+	var reply DirectReply
+	// read byte by byte, data of impl may follow the reply
+	err = gob.NewDecoder(utils.ByteReader{Reader: conn}).Decode(&reply)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		return err
+	}
+	if !reply.Accepted {
+		// responsers before status codes leave it empty
+		if reply.Code == types.STATUS_OK {
+			reply.Code = types.STATUS_FAILED
+		}
+		return types.NewError(reply.Code, "rejected by %s: %s", target, reply.Message)
+	}
+	return nil
+}
+
+// rejectDirect tells dialer why its request failed and closes sock
+func rejectDirect(sock net.Conn, reason error) {
+	code, msg := types.ErrorStatus(reason)
+	err := gob.NewEncoder(sock).Encode(DirectReply{Code: code, Message: msg})
+	if err != nil {
+		logrus.Error(err)
+	}
+	sock.Close()
+}
@@ -1,9 +1,12 @@
 package conn
 
 import (
+	"context"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"net"
+	"os"
 	"reflect"
 	"sync"
 	"time"
@@ -14,6 +17,23 @@ import (
 	"github.com/suutaku/sshx/pkg/types"
 )
 
+// limit of connection setup, services racing after it are aborted
+const setupTimeout = 30 * time.Second
+
+// pairs of same key wait no longer than this for one of them to be ready
+const pairReadyTimeout = setupTimeout
+
+// failures told by remote first, then more specific ones
+var failurePriority = map[int32]int{
+	types.STATUS_ACL_DENIED:   6,
+	types.STATUS_IMPL_UNKNOWN: 6,
+	types.STATUS_REMOTE_ERROR: 6,
+	types.STATUS_ICE_FAILED:   4,
+	types.STATUS_PEER_OFFLINE: 3,
+	types.STATUS_TIMEOUT:      2,
+	types.STATUS_FAILED:       1,
+}
+
 // manage all supported connection implementations
 type ConnectionManager struct {
 	css []ConnectionService
@@ -48,43 +68,178 @@ func (cm *ConnectionManager) Stop() {
 	}
 }
 
-func (cm *ConnectionManager) CreateConnection(sender *impl.Sender, sock net.Conn, poolId types.PoolId) error {
-	for i := 0; i < len(cm.css); i++ {
-
-		if cm.css[i].IsReady() {
-			go func(cs ConnectionService) {
-				s, c := net.Pipe()
-				err := cs.CreateConnection(sender, c, poolId)
-				if err != nil {
-					logrus.Error(err, i)
-					return
-				}
-				sender.PairId = []byte(poolId.String(CONNECTION_DRECT_OUT))
-				err = cs.ResponseTCP(sender, sock)
-				if err != nil {
-					logrus.Error(err, i)
-					return
+func (cm *ConnectionManager) CreateConnection(ctx context.Context, sender *impl.Sender, sock net.Conn, poolId types.PoolId) error {
+	readyServices := make([]ConnectionService, 0)
+	for _, v := range cm.css {
+		if v.IsReady() {
+			readyServices = append(readyServices, v)
+		}
+	}
+	if len(readyServices) == 0 {
+		err := types.NewError(types.STATUS_FAILED, "no connection service ready")
+		cm.Refuse(sender, sock, err)
+		return err
+	}
+	if imp := sender.GetImpl(); imp != nil && !imp.IsNeedConnect() {
+		// impl runs on this device only, every service in a race would run it once
+		readyServices = readyServices[:1]
+	}
+	type attempt struct {
+		cs   ConnectionService
+		sock net.Conn
+		err  error
+	}
+	ctx, cancel := context.WithTimeout(ctx, setupTimeout)
+	watcher := watchRequester(sock, cancel)
+	// race all services, the first connected one is kept
+	results := make(chan attempt, len(readyServices))
+	for _, v := range readyServices {
+		go func(cs ConnectionService) {
+			s, c := net.Pipe()
+			err := cs.CreateConnection(ctx, sender, c, poolId)
+			results <- attempt{cs, s, err}
+		}(v)
+	}
+	go func() {
+		defer cancel()
+		var winner ConnectionService
+		var failure error
+		for range readyServices {
+			res := <-results
+			if res.err != nil {
+				if winner == nil && !errors.Is(res.err, context.Canceled) {
+					logrus.Error(res.err)
+					if failure == nil || moreSpecific(res.err, failure) {
+						failure = res.err
+					}
+					if isRemoteVerdict(res.err) {
+						// other services reach the same device
+						cancel()
+					}
+				} else {
+					logrus.Debug(res.err)
 				}
-				utils.Pipe(&sock, &s)
-			}(cm.css[i])
+				res.sock.Close()
+				continue
+			}
+			if winner != nil {
+				// lost the race, its pair goes down with the pipe
+				logrus.Debug("drop connection of ", reflect.TypeOf(res.cs), ", ", reflect.TypeOf(winner), " won")
+				res.sock.Close()
+				continue
+			}
+			winner = res.cs
+			// abort other services, setup of winner is done
+			cancel()
+			early := watcher.stop()
+			sender.PairId = []byte(poolId.String(CONNECTION_DRECT_OUT))
+			err := winner.ResponseTCP(sender, sock)
+			if err == nil && len(early) > 0 {
+				_, err = res.sock.Write(early)
+			}
+			if err != nil {
+				logrus.Error(err)
+				res.sock.Close()
+				continue
+			}
+			go utils.Pipe(&sock, &res.sock)
+		}
+		// tell dialer if no service made it
+		if winner == nil {
+			watcher.stop()
+			if failure == nil {
+				// all aborted, requester is gone
+				failure = context.Canceled
+			}
+			cm.Refuse(sender, sock, failure)
+		}
+	}()
+	return nil
+}
+
+// moreSpecific reports whether err tells requester more than old,
+// the later one of the same kind tried longer
+func moreSpecific(err, old error) bool {
+	code, _ := types.ErrorStatus(err)
+	oldCode, _ := types.ErrorStatus(old)
+	return failurePriority[code] >= failurePriority[oldCode]
+}
+
+// isRemoteVerdict reports whether err was decided by remote device
+func isRemoteVerdict(err error) bool {
+	code, _ := types.ErrorStatus(err)
+	return code == types.STATUS_ACL_DENIED || code == types.STATUS_IMPL_UNKNOWN || code == types.STATUS_REMOTE_ERROR
+}
+
+// Refuse tells requester why its request failed and closes sock
+func (cm *ConnectionManager) Refuse(sender *impl.Sender, sock net.Conn, reason error) {
+	sender.Status, sender.Message = types.ErrorStatus(reason)
+	err := gob.NewEncoder(sock).Encode(sender)
+	if err != nil {
+		logrus.Debug("cannot tell requester ", reason, ": ", err)
+	}
+	sock.Close()
+}
+
+// requesterWatch cancels connection setup once requester closed its socket, e.g. Ctrl-C of CLI
+type requesterWatch struct {
+	sock net.Conn
+	done chan struct{}
+	data []byte
+}
+
+func watchRequester(sock net.Conn, cancel context.CancelFunc) *requesterWatch {
+	w := &requesterWatch{
+		sock: sock,
+		done: make(chan struct{}),
+	}
+	go func() {
+		defer close(w.done)
+		buf := make([]byte, 1)
+		n, err := sock.Read(buf)
+		w.data = buf[:n]
+		if err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+			logrus.Debug("requester left: ", err)
+			cancel()
 		}
+	}()
+	return w
+}
+
+// stop stops watching and returns data read meanwhile, requester should not send any before response
+func (w *requesterWatch) stop() []byte {
+	w.sock.SetReadDeadline(time.Now())
+	<-w.done
+	w.sock.SetReadDeadline(time.Time{})
+	return w.data
+}
+
+// RemoveConnection closes pair of pairId
+func (cm *ConnectionManager) RemoveConnection(pairId string) error {
+	pair := cm.stm.GetPair(pairId)
+	if pair == nil {
+		return types.NewError(types.STATUS_NOT_FOUND, "no session %s", pairId)
+	}
+	sender := impl.NewSender(pair.GetImpl(), types.OPTION_TYPE_DOWN)
+	sender.PairId = []byte(pairId)
+	return cm.destroy(sender)
+}
 
-		// go func(idx int) {
-		// 	if cm.css[idx].IsReady() {
-		// 		err := cm.css[idx].CreateConnection(sender, sock, poolId)
-		// 		if err != nil {
-		// 			logrus.Error(err)
-		// 			return
-		// 		}
-		// 	}
-		// }(i)
+// destroy asks every service to close the pair, only the one carrying it does
+func (cm *ConnectionManager) destroy(sender *impl.Sender) error {
+	if cm.stm.GetPair(string(sender.PairId)) == nil {
+		return types.NewError(types.STATUS_NOT_FOUND, "no session %s", string(sender.PairId))
+	}
+	for _, v := range cm.css {
+		v.DestroyConnection(sender)
 	}
 	return nil
 }
 
 func (cm *ConnectionManager) DestroyConnection(sender *impl.Sender, conn net.Conn) error {
-	err := cm.css[0].DestroyConnection(sender)
+	err := cm.destroy(sender)
 	if err != nil {
+		cm.Refuse(sender, conn, err)
 		return err
 	}
 	err = cm.css[0].ResponseTCP(sender, conn)
@@ -101,6 +256,7 @@ func (cm *ConnectionManager) AttachConnection(sender *impl.Sender, sock net.Conn
 		err := cm.css[0].AttachConnection(sender, c)
 		if err != nil {
 			logrus.Error(err)
+			cm.Refuse(sender, sock, err)
 			return
 		}
 		logrus.Debug("attached ", sender.GetImpl().HostId())
@@ -140,12 +296,19 @@ func (cm *ConnectionManager) Status(sender impl.Sender, conn net.Conn) error {
 	return nil
 }
 
+// Stat returns status of all pairs
+func (cm *ConnectionManager) Stat() []types.Status {
+	return cm.stm.Stat()
+}
+
 type StatManager struct {
 	stats    map[string]types.Status
 	children map[string][]string
 	cpPool   map[string]Connection
-	running  bool
-	lock     sync.Mutex
+	// incoming pairs of other transports sharing key with the one in cpPool
+	standby map[string][]Connection
+	running bool
+	lock    sync.Mutex
 }
 
 func NewStatManager() *StatManager {
@@ -154,6 +317,7 @@ func NewStatManager() *StatManager {
 		stats:    make(map[string]types.Status),
 		children: make(map[string][]string),
 		cpPool:   make(map[string]Connection),
+		standby:  make(map[string][]Connection),
 	}
 }
 
@@ -181,7 +345,10 @@ func (stm *StatManager) putStat(stat types.Status) {
 		logrus.Warn("empty paird id for status: ", stat)
 		return
 	}
-	if stm.stats[stat.PairId].PairId == stat.PairId {
+	if old, ok := stm.stats[stat.PairId]; ok {
+		// pair replaced by another transport
+		old.Transport = stat.Transport
+		stm.stats[stat.PairId] = old
 		return
 	}
 	stm.stats[stat.PairId] = stat
@@ -207,6 +374,8 @@ func PoolIdFromInt(id int64) string {
 }
 
 func (stm *StatManager) Stat() []types.Status {
+	stm.lock.Lock()
+	defer stm.lock.Unlock()
 	return stm.getStat()
 }
 
@@ -215,12 +384,14 @@ func (stm *StatManager) RemovePair(id CleanRequest) {
 	defer stm.lock.Unlock()
 	children := stm.getChildren(id.Key)
 	logrus.Debug("ready to clear children ", children)
+	// children of a removed parent go down whatever transport carries them
+	parentMatched := stm.cpPool[id.Key] != nil && stm.cpPool[id.Key].Name() == id.ConnectionName
 	// close children
 	for _, v := range children {
-		if stm.cpPool[v] != nil && stm.cpPool[v].Name() == id.ConnectionName {
+		if stm.cpPool[v] != nil && (parentMatched || stm.cpPool[v].Name() == id.ConnectionName) {
 			stm.cpPool[v].Close()
 			delete(stm.cpPool, v)
-			stm.removeStat(id.Key)
+			stm.removeStat(v)
 		}
 
 	}
@@ -230,6 +401,40 @@ func (stm *StatManager) RemovePair(id CleanRequest) {
 		delete(stm.cpPool, id.Key)
 		stm.removeStat(id.Key)
 		stm.removeParent(id.Key)
+		stm.promoteStandby(id.Key)
+	} else {
+		stm.removeStandby(id)
+	}
+}
+
+// promoteStandby puts the first standby pair of key in place of the removed one
+func (stm *StatManager) promoteStandby(key string) {
+	pairs := stm.standby[key]
+	if len(pairs) == 0 {
+		return
+	}
+	if len(pairs) == 1 {
+		delete(stm.standby, key)
+	} else {
+		stm.standby[key] = pairs[1:]
+	}
+	logrus.Debug("promote standby pair ", key, " ", pairs[0].Name())
+	stm.doAddPair(pairs[0])
+}
+
+func (stm *StatManager) removeStandby(id CleanRequest) {
+	pairs := stm.standby[id.Key]
+	for i, v := range pairs {
+		if v.Name() == id.ConnectionName {
+			v.Close()
+			pairs = append(pairs[:i], pairs[i+1:]...)
+			break
+		}
+	}
+	if len(pairs) == 0 {
+		delete(stm.standby, id.Key)
+	} else {
+		stm.standby[id.Key] = pairs
 	}
 }
 
@@ -241,6 +446,7 @@ func (stm *StatManager) doAddPair(pair Connection) error {
 		TargetId:  pair.TargetId(),
 		ImplType:  pair.GetImpl().Code(),
 		StartTime: time.Now(),
+		Transport: pair.Transport(),
 	}
 
 	if pair.GetImpl().ParentId() != "" {
@@ -260,34 +466,45 @@ func (stm *StatManager) AddPair(pair Connection) error {
 		return fmt.Errorf("pair was empty")
 	}
 
-	oldPair := stm.cpPool[pair.PoolId().String(pair.Direction())]
-
-	if oldPair != nil {
-		if oldPair.IsReady() {
+	key := pair.PoolId().String(pair.Direction())
+	deadline := time.Now().Add(pairReadyTimeout)
+	for {
+		stm.lock.Lock()
+		oldPair := stm.cpPool[key]
+		switch {
+		case oldPair == nil:
+			err := stm.doAddPair(pair)
+			stm.lock.Unlock()
+			return err
+		case pair.Direction() == CONNECTION_DRECT_IN && oldPair.Name() != pair.Name():
+			// dialer races transports and closes the losers, keep all of them until then
+			stm.standby[key] = append(stm.standby[key], pair)
+			stm.lock.Unlock()
+			logrus.Debug("standby pair ", key, " ", pair.Name())
+			return nil
+		case oldPair.IsReady():
+			stm.lock.Unlock()
 			pair.Close()
 			return fmt.Errorf("pair already exist, drop %s", pair.Name())
+		case pair.IsReady():
+			// replace old pair not ready
+			err := stm.doAddPair(pair)
+			stm.lock.Unlock()
+			return err
 		}
-		// old pair not ready
-		if pair.IsReady() {
-			//replace old pair
-			return stm.doAddPair(pair)
-		}
-		for !oldPair.IsReady() && !pair.IsReady() {
-			logrus.Debug("watting %s\n", pair.Name())
-			time.Sleep(500 * time.Millisecond)
-		}
-		if oldPair.IsReady() {
-			return fmt.Errorf("pair already exist, drop %s", pair.Name())
-		}
-		if pair.IsReady() {
-			return fmt.Errorf("replace pair from %s to %s ", oldPair.Name(), pair.Name())
+		stm.lock.Unlock()
+		// a pair closed by losing the race never gets ready
+		if time.Now().After(deadline) {
+			pair.Close()
+			return fmt.Errorf("neither %s nor %s ready, drop %s", oldPair.Name(), pair.Name(), pair.Name())
 		}
-	} else {
-		return stm.doAddPair(pair)
+		logrus.Debugf("watting %s", pair.Name())
+		time.Sleep(500 * time.Millisecond)
 	}
-	return nil
 }
 
 func (stm *StatManager) GetPair(id string) Connection {
+	stm.lock.Lock()
+	defer stm.lock.Unlock()
 	return stm.cpPool[id]
 }
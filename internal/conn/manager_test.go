@@ -0,0 +1,95 @@
+package conn
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/suutaku/sshx/pkg/impl"
+	"github.com/suutaku/sshx/pkg/types"
+)
+
+type testPair struct {
+	*BaseConnection
+	name string
+}
+
+func newTestPair(name string, id int64, direct int32, ready bool) *testPair {
+	imp := impl.NewSSH("root@peer", false, "", false)
+	pair := &testPair{
+		BaseConnection: NewBaseConnection(imp, "self", "peer", *types.NewPoolId(id, imp.Code()), direct, imp.Code()),
+		name:           name,
+	}
+	pair.ready = ready
+	return pair
+}
+
+func (p *testPair) Dial(ctx context.Context) error { return nil }
+func (p *testPair) Name() string                   { return p.name }
+func (p *testPair) Transport() string              { return p.name }
+
+func TestStatManagerAddPair(t *testing.T) {
+	cases := []struct {
+		name    string
+		old     *testPair
+		pair    *testPair
+		wantErr bool
+		kept    string
+	}{
+		{"new", nil, newTestPair("direct", 1, CONNECTION_DRECT_OUT, false), false, "direct"},
+		{"old ready", newTestPair("direct", 1, CONNECTION_DRECT_OUT, true), newTestPair("quic", 1, CONNECTION_DRECT_OUT, true), true, "direct"},
+		{"replace old not ready", newTestPair("direct", 1, CONNECTION_DRECT_OUT, false), newTestPair("quic", 1, CONNECTION_DRECT_OUT, true), false, "quic"},
+		{"standby incoming", newTestPair("direct", 1, CONNECTION_DRECT_IN, true), newTestPair("quic", 1, CONNECTION_DRECT_IN, true), false, "direct"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			stm := NewStatManager()
+			if c.old != nil {
+				if err := stm.AddPair(c.old); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := stm.AddPair(c.pair)
+			if (err != nil) != c.wantErr {
+				t.Errorf("AddPair() error = %v, want error %v", err, c.wantErr)
+			}
+			key := c.pair.PoolId().String(c.pair.Direction())
+			if got := stm.GetPair(key); got == nil || got.Name() != c.kept {
+				t.Errorf("kept pair %v, want %s", got, c.kept)
+			}
+			if n := len(stm.Stat()); n != 1 {
+				t.Errorf("%d status, want 1", n)
+			}
+		})
+	}
+}
+
+func TestStatManagerConcurrent(t *testing.T) {
+	stm := NewStatManager()
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		for _, name := range []string{"direct", "quic", "webrtc"} {
+			wg.Add(1)
+			go func(id int64, name string) {
+				defer wg.Done()
+				pair := newTestPair(name, id, CONNECTION_DRECT_IN, true)
+				stm.AddPair(pair)
+				stm.GetPair(pair.PoolId().String(pair.Direction()))
+				stm.Stat()
+			}(int64(i+1), name)
+		}
+	}
+	wg.Wait()
+	if n := len(stm.Stat()); n != 50 {
+		t.Errorf("%d status, want 50", n)
+	}
+	for i := 1; i <= 50; i++ {
+		key := types.NewPoolId(int64(i), types.APP_TYPE_SSH).String(CONNECTION_DRECT_IN)
+		if stm.GetPair(key) == nil {
+			t.Errorf("no pair %s", key)
+		}
+		if n := len(stm.standby[key]); n != 2 {
+			t.Errorf("%d standby pairs of %s, want 2", n, key)
+		}
+	}
+}
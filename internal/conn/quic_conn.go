@@ -0,0 +1,135 @@
+package conn
+
+import (
+	"context"
+	"encoding/gob"
+	"net"
+	"reflect"
+	"sync"
+
+	"github.com/quic-go/quic-go"
+	"github.com/sirupsen/logrus"
+	"github.com/suutaku/sshx/internal/utils"
+	"github.com/suutaku/sshx/pkg/impl"
+	"github.com/suutaku/sshx/pkg/types"
+)
+
+// quicStream makes a QUIC stream usable as net.Conn
+type quicStream struct {
+	quic.Stream
+	conn      quic.Connection
+	release   func()
+	closeOnce sync.Once
+}
+
+func (s *quicStream) LocalAddr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+func (s *quicStream) RemoteAddr() net.Addr {
+	return s.conn.RemoteAddr()
+}
+
+// Close closes both directions, Stream.Close only finishes writing
+func (s *quicStream) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		s.CancelRead(0)
+		err = s.Stream.Close()
+		if s.release != nil {
+			s.release()
+		}
+	})
+	return err
+}
+
+// QUICConnection is a pair carried by one stream of a QUIC connection
+type QUICConnection struct {
+	BaseConnection
+	net.Conn
+	CleanChan *chan CleanRequest
+	// dialer only, opens a stream to target
+	open func(context.Context) (net.Conn, error)
+}
+
+func NewQUICConnection(impl impl.Impl, nodeId string, targetId string, poolId types.PoolId, direct int32, cleanChan *chan CleanRequest, open func(context.Context) (net.Conn, error)) *QUICConnection {
+	return &QUICConnection{
+		BaseConnection: *NewBaseConnection(impl, nodeId, targetId, poolId, direct, impl.Code()),
+		CleanChan:      cleanChan,
+		open:           open,
+	}
+}
+
+func (qc *QUICConnection) Close() {
+	qc.BaseConnection.Close()
+	if qc.Conn != nil {
+		qc.Conn.Close()
+	}
+}
+
+func (qc *QUICConnection) Name() string {
+	if t := reflect.TypeOf(qc); t.Kind() == reflect.Ptr {
+		return "*" + t.Elem().Name()
+	} else {
+		return t.Name()
+	}
+}
+
+func (qc *QUICConnection) Transport() string {
+	return "quic"
+}
+
+func (qc *QUICConnection) Dial(ctx context.Context) error {
+	if qc.impl.IsNeedConnect() {
+		logrus.Debug("dial ", qc.TargetId(), " by quic")
+		conn, err := qc.open(ctx)
+		if err != nil {
+			return err
+		}
+		info := DirectInfo{
+			ImplCode: qc.impl.Code(),
+			HostId:   qc.nodeId,
+			Id:       qc.poolId.Raw(),
+		}
+		err = requestDirect(ctx, conn, info, qc.TargetId())
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		qc.Conn = conn
+		qc.pipe()
+	} else {
+		logrus.Error("NOT create connection for ", impl.GetImplName(qc.impl.Code()))
+	}
+	err := qc.BaseConnection.Dial()
+	if err != nil {
+		return err
+	}
+	qc.Exit <- err
+	qc.Ready()
+	return nil
+}
+
+func (qc *QUICConnection) Response() error {
+	qc.Ready()
+	err := qc.BaseConnection.Response()
+	if err != nil {
+		rejectDirect(qc.Conn, types.NewError(types.STATUS_REMOTE_ERROR, "%v", err))
+		return err
+	}
+	err = gob.NewEncoder(qc.Conn).Encode(DirectReply{Accepted: true})
+	if err != nil {
+		return err
+	}
+	qc.pipe()
+	return nil
+}
+
+func (qc *QUICConnection) pipe() {
+	implConn := qc.impl.Conn()
+	go func() {
+		utils.Pipe(&implConn, &qc.Conn)
+		logrus.Debug("quic stream closed ", qc.poolId.String(qc.Direction()))
+		*qc.CleanChan <- CleanRequest{qc.poolId.String(qc.Direction()), qc.Name()}
+	}()
+}
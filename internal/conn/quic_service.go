@@ -0,0 +1,242 @@
+package conn
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/sirupsen/logrus"
+	"github.com/suutaku/sshx/pkg/conf"
+	"github.com/suutaku/sshx/pkg/impl"
+	"github.com/suutaku/sshx/pkg/types"
+)
+
+const (
+	quicALPN        = "sshx"
+	quicDialTimeout = 5 * time.Second
+)
+
+var quicConfig = &quic.Config{
+	MaxIdleTimeout:  30 * time.Second,
+	KeepAlivePeriod: 10 * time.Second,
+	Allow0RTT:       true,
+}
+
+// quicPeer is a QUIC connection to a remote node, shared by pairs of the node
+type quicPeer struct {
+	conn      quic.EarlyConnection
+	streams   int
+	idleTimer *time.Timer
+}
+
+// QUICService carries pairs over QUIC streams on UDP port of DirectPort,
+// one connection per remote address and one stream per pair
+type QUICService struct {
+	BaseConnectionService
+	*lanBase
+	peers    map[string]*quicPeer
+	peerLock sync.Mutex
+	// resumed sessions send first stream in 0-RTT
+	sessions tls.ClientSessionCache
+}
+
+func NewQUICService(cm *conf.ConfManager) *QUICService {
+	return &QUICService{
+		BaseConnectionService: *NewBaseConnectionService(cm.Conf().ID),
+		lanBase:               newLanBase(cm),
+		peers:                 make(map[string]*quicPeer),
+		sessions:              tls.NewLRUClientSessionCache(64),
+	}
+}
+
+func (qs *QUICService) Start() error {
+	if !qs.hasCertificate() {
+		return fmt.Errorf("quic service disabled without node identity")
+	}
+	tlsConf := qs.tlsConfig("")
+	tlsConf.NextProtos = []string{quicALPN}
+	listener, err := quic.ListenAddrEarly(fmt.Sprintf(":%d", qs.port()), tlsConf, quicConfig)
+	if err != nil {
+		logrus.Error(err)
+		return err
+	}
+	qs.BaseConnectionService.Start()
+	go func() {
+		for qs.running {
+			conn, err := listener.Accept(context.Background())
+			if err != nil {
+				logrus.Error(err)
+				return
+			}
+			go qs.serve(conn)
+		}
+		listener.Close()
+	}()
+	return nil
+}
+
+func (qs *QUICService) serve(conn quic.EarlyConnection) {
+	// streams may arrive in 0-RTT which can be replayed, wait for the client to finish handshake
+	select {
+	case <-conn.HandshakeComplete():
+	case <-conn.Context().Done():
+		return
+	}
+	peerId, err := qs.peerId(conn.ConnectionState().TLS.PeerCertificates[0])
+	if err != nil {
+		logrus.Warn(err)
+		conn.CloseWithError(0, err.Error())
+		return
+	}
+	logrus.Debug("quic connection from ", peerId, " at ", conn.RemoteAddr())
+	for {
+		stream, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			logrus.Debug("quic connection from ", peerId, " closed: ", err)
+			return
+		}
+		go qs.serveStream(&quicStream{Stream: stream, conn: conn}, peerId)
+	}
+}
+
+func (qs *QUICService) serveStream(sock net.Conn, peerId string) {
+	imp, info, err := qs.acceptInfo(sock, peerId)
+	if err != nil {
+		logrus.Warn(err)
+		return
+	}
+	poolId := types.NewPoolId(info.Id, imp.Code())
+	pair := NewQUICConnection(imp, qs.Id(), info.HostId, *poolId, CONNECTION_DRECT_IN, &qs.CleanChan, nil)
+	pair.Conn = sock
+	err = pair.Response()
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	qs.AddPair(pair)
+}
+
+// getPeer returns connection to addr, dial if not connected
+func (qs *QUICService) getPeer(ctx context.Context, target, addr string) (*quicPeer, error) {
+	qs.peerLock.Lock()
+	peer := qs.alivePeer(addr)
+	qs.peerLock.Unlock()
+	if peer != nil {
+		return peer, nil
+	}
+	tlsConf := qs.tlsConfig(target)
+	tlsConf.NextProtos = []string{quicALPN}
+	tlsConf.ClientSessionCache = qs.sessions
+	dialCtx, cancel := context.WithTimeout(ctx, quicDialTimeout)
+	defer cancel()
+	// dialed without lock, pairs to other peers go on meanwhile
+	conn, err := quic.DialAddrEarly(dialCtx, addr, tlsConf, quicConfig)
+	if err != nil {
+		return nil, dialError(ctx, target, addr, err)
+	}
+	logrus.Debug("quic connected to ", target, " at ", addr, ", 0-RTT: ", conn.ConnectionState().Used0RTT)
+	qs.peerLock.Lock()
+	defer qs.peerLock.Unlock()
+	if peer := qs.alivePeer(addr); peer != nil {
+		// dialed by another pair too, keep the first one
+		conn.CloseWithError(0, "duplicated")
+		return peer, nil
+	}
+	peer = &quicPeer{conn: conn}
+	qs.peers[addr] = peer
+	return peer, nil
+}
+
+// alivePeer returns connection to addr not closed yet, caller holds peerLock
+func (qs *QUICService) alivePeer(addr string) *quicPeer {
+	if peer := qs.peers[addr]; peer != nil && peer.conn.Context().Err() == nil {
+		return peer
+	}
+	return nil
+}
+
+// openStream opens a stream for a pair, connection is closed if no stream used it for a while
+func (qs *QUICService) openStream(ctx context.Context, target, addr string) (net.Conn, error) {
+	peer, err := qs.getPeer(ctx, target, addr)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, quicDialTimeout)
+	defer cancel()
+	stream, err := peer.conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	qs.peerLock.Lock()
+	peer.streams++
+	if peer.idleTimer != nil {
+		peer.idleTimer.Stop()
+		peer.idleTimer = nil
+	}
+	qs.peerLock.Unlock()
+	return &quicStream{Stream: stream, conn: peer.conn, release: func() { qs.releasePeer(addr, peer) }}, nil
+}
+
+func (qs *QUICService) releasePeer(addr string, peer *quicPeer) {
+	qs.peerLock.Lock()
+	defer qs.peerLock.Unlock()
+	peer.streams--
+	if peer.streams > 0 || peer.idleTimer != nil {
+		return
+	}
+	peer.idleTimer = time.AfterFunc(peerIdleTimeout, func() {
+		qs.peerLock.Lock()
+		defer qs.peerLock.Unlock()
+		if peer.streams > 0 {
+			return
+		}
+		logrus.Debug("close idle quic connection to ", addr)
+		peer.conn.CloseWithError(0, "idle")
+		if qs.peers[addr] == peer {
+			delete(qs.peers, addr)
+		}
+	})
+}
+
+func (qs *QUICService) CreateConnection(ctx context.Context, sender *impl.Sender, sock net.Conn, poolId types.PoolId) error {
+	err := qs.BaseConnectionService.CreateConnection(ctx, sender, sock, poolId)
+	if err != nil {
+		return err
+	}
+	iface := sender.GetImpl()
+	if iface == nil {
+		return fmt.Errorf("unknown impl")
+	}
+	if !sender.Detach {
+		iface.SetConn(sock)
+	}
+	target := iface.HostId()
+	addr := ""
+	if iface.IsNeedConnect() {
+		addr, err = qs.dialAddress(target)
+		if err != nil {
+			return err
+		}
+	}
+	pair := NewQUICConnection(iface, qs.Id(), target, poolId, CONNECTION_DRECT_OUT, &qs.CleanChan, func(ctx context.Context) (net.Conn, error) {
+		return qs.openStream(ctx, target, addr)
+	})
+	err = pair.Dial(ctx)
+	if err != nil {
+		return err
+	}
+	return qs.AddPair(pair)
+}
+
+func (qs *QUICService) DestroyConnection(tmp *impl.Sender) error {
+	pair := qs.GetPair(string(tmp.PairId))
+	if pair == nil {
+		return fmt.Errorf("cannot get pair for %s", string(tmp.PairId))
+	}
+	qs.RemovePair(CleanRequest{string(tmp.PairId), (&QUICConnection{}).Name()})
+	return nil
+}
@@ -1,6 +1,7 @@
 package conn
 
 import (
+	"context"
 	"encoding/gob"
 	"fmt"
 	"net"
@@ -14,7 +15,8 @@ import (
 type ConnectionService interface {
 	Start() error
 	SetStateManager(*StatManager) error
-	CreateConnection(*impl.Sender, net.Conn, types.PoolId) error
+	// create connection for sender, setup is aborted and remote half torn down once ctx done
+	CreateConnection(context.Context, *impl.Sender, net.Conn, types.PoolId) error
 	DestroyConnection(*impl.Sender) error
 	AttachConnection(*impl.Sender, net.Conn) error
 	ResponseTCP(*impl.Sender, net.Conn) error
@@ -91,7 +93,7 @@ func (base *BaseConnectionService) SetStateManager(stm *StatManager) error {
 	return nil
 }
 
-func (base *BaseConnectionService) CreateConnection(sender *impl.Sender, conn net.Conn, poolId types.PoolId) error {
+func (base *BaseConnectionService) CreateConnection(ctx context.Context, sender *impl.Sender, conn net.Conn, poolId types.PoolId) error {
 	return nil
 }
 
@@ -110,7 +112,7 @@ func (base *BaseConnectionService) AttachConnection(sender *impl.Sender, sock ne
 	imp := sender.GetImpl()
 	pair := base.GetPair(string(sender.PairId))
 	if pair == nil {
-		return fmt.Errorf("cannot attach impl with id: %s", string(sender.PairId))
+		return types.NewError(types.STATUS_NOT_FOUND, "no session %s", string(sender.PairId))
 	}
 	if impl.GetImplName(pair.GetImpl().Code()) != impl.GetImplName(imp.Code()) {
 		return fmt.Errorf("cannot impl type dismatch, except %s, got %s", impl.GetImplName(pair.GetImpl().Code()), impl.GetImplName(imp.Code()))
@@ -1,9 +1,13 @@
 package conn
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"reflect"
+	"sync"
 	"time"
 
 	"github.com/suutaku/sshx/pkg/impl"
@@ -13,31 +17,93 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// max size of a data channel message
+const maxMessageSize = 16 * 1024
+
+// protocol of data channels whose responser sends pairStatus as first message
+const statusProtocol = "sshx-status"
+
+// pairStatus tells dialer whether responser is ready, sent as text message
+type pairStatus struct {
+	Code    int32  `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// sendStatus sends result of Response on data channels asking for it
+func sendStatus(dc *webrtc.DataChannel, reason error) error {
+	if dc.Protocol() != statusProtocol {
+		return nil
+	}
+	st := pairStatus{}
+	if reason != nil {
+		st.Code, st.Message = types.ErrorStatus(reason)
+	}
+	bs, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return dc.SendText(string(bs))
+}
+
+// keep at most this much data during ice restart, writer blocks if exceeded
+const maxRecoveryBuffer = 4 * 1024 * 1024
+
+// Wrapper writes to data channel, data is kept while peer connection recovering
 type Wrapper struct {
 	*webrtc.DataChannel
+	peer *webrtcPeer
+	buf  bytes.Buffer
+	lock sync.Mutex
 }
 
 func (s *Wrapper) Write(b []byte) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.peer != nil && s.peer.IsRecovering() {
+		if s.buf.Len()+len(b) <= maxRecoveryBuffer {
+			s.buf.Write(b)
+			return len(b), nil
+		}
+		s.peer.waitRecovered()
+	}
+	if err := s.flush(); err != nil {
+		return 0, err
+	}
 	err := s.DataChannel.Send(b)
 	return len(b), err
 }
 
+// Flush sends data kept during recovery, waits if still recovering
+func (s *Wrapper) Flush() error {
+	if s.peer != nil {
+		s.peer.waitRecovered()
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.flush()
+}
+
+func (s *Wrapper) flush() error {
+	for s.buf.Len() > 0 {
+		if err := s.DataChannel.Send(s.buf.Next(maxMessageSize)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 type WebRTC struct {
 	BaseConnection
-	*webrtc.PeerConnection
-	conf    webrtc.Configuration
-	stmChan *chan CleanRequest
+	peer      *webrtcPeer
+	dc        *webrtc.DataChannel
+	writer    *Wrapper
+	stmChan   *chan CleanRequest
+	closeOnce sync.Once
 }
 
-func NewWebRTC(conf webrtc.Configuration, impl impl.Impl, nodeId string, targetId string, poolId types.PoolId, direct int32, stmChan *chan CleanRequest) *WebRTC {
-	pc, err := webrtc.NewPeerConnection(conf)
-	if err != nil {
-		logrus.Error("rtc error:", err)
-		return nil
-	}
+func NewWebRTC(peer *webrtcPeer, impl impl.Impl, nodeId string, targetId string, poolId types.PoolId, direct int32, stmChan *chan CleanRequest) *WebRTC {
 	ret := &WebRTC{
-		PeerConnection: pc,
-		conf:           conf,
+		peer:           peer,
 		BaseConnection: *NewBaseConnection(impl, nodeId, targetId, poolId, direct, impl.Code()),
 		stmChan:        stmChan,
 	}
@@ -53,74 +119,76 @@ func (pair *WebRTC) Name() string {
 	}
 }
 
-// create responser
+func (pair *WebRTC) Transport() string {
+	return "webrtc"
+}
+
+// create responser on data channel opened by remote, impl must be ready
+// before return, remote may send data once the channel accepted
 func (pair *WebRTC) Response() error {
 	logrus.Debug("pair response")
-	peer, err := webrtc.NewPeerConnection(pair.conf)
+	dc := pair.dc
+	if dc == nil {
+		return fmt.Errorf("no data channel for %s", pair.poolId.String(pair.Direction()))
+	}
+	err := pair.BaseConnection.Response()
 	if err != nil {
 		pair.Exit <- err
-		logrus.Print(err)
-		pair.Close()
 		return err
 	}
-	peer.OnDataChannel(func(dc *webrtc.DataChannel) {
-		//dc.Lock()
-		dc.OnOpen(func() {
-			err := pair.BaseConnection.Response()
-			if err != nil {
-				logrus.Error(err)
-				pair.Exit <- err
-				pair.Close()
-				return
-			}
-			pair.Exit <- err
-			pair.Ready()
-			logrus.Info("data channel open 2")
-			n, err := io.Copy(&Wrapper{dc}, pair.impl.Reader())
-			for dc.BufferedAmount() > 0 {
-				time.Sleep(100 * time.Millisecond)
-			}
-			logrus.Info("trans2 ", n, err)
-			pair.Exit <- fmt.Errorf("io copy break")
-			dc.Close()
+	pair.writer = &Wrapper{DataChannel: dc, peer: pair.peer}
+	pair.peer.addPair(pair.poolId.String(pair.Direction()), pair)
+	dc.OnOpen(func() {
+		pair.Exit <- nil
+		pair.Ready()
+		logrus.Info("data channel open 2")
+		// before any data of impl
+		if err := sendStatus(dc, nil); err != nil {
+			logrus.Error(err)
+		}
+		n, err := io.Copy(pair.writer, pair.impl.Reader())
+		pair.writer.Flush()
+		for dc.BufferedAmount() > 0 {
+			time.Sleep(100 * time.Millisecond)
+		}
+		logrus.Info("trans2 ", n, err)
+		pair.Exit <- fmt.Errorf("io copy break")
+		pair.Close()
+	})
+	dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+		if pair.impl == nil {
 			pair.Close()
-		})
-		dc.OnMessage(func(msg webrtc.DataChannelMessage) {
-			if pair.impl == nil {
-				pair.Close()
-				return
-			}
-			_, err := pair.impl.Writer().Write(msg.Data)
-			if err != nil {
-				logrus.Error("sock write failed:", err)
-				pair.Close()
-				return
-			}
-		})
-		dc.OnClose(func() {
-			logrus.Debug("data channel close 2")
-			pair.Exit <- nil
+			return
+		}
+		_, err := pair.impl.Writer().Write(msg.Data)
+		if err != nil {
+			logrus.Error("sock write failed:", err)
 			pair.Close()
-		})
+			return
+		}
+	})
+	dc.OnClose(func() {
+		logrus.Debug("data channel close 2")
+		pair.Exit <- nil
+		pair.Close()
 	})
-
-	pair.PeerConnection = peer
 	return nil
 }
 
-// create dialer
-func (pair *WebRTC) Dial() error {
+// create dialer, data channel opens later so ctx is not used
+func (pair *WebRTC) Dial(ctx context.Context) error {
 	logrus.Debug("pair dial")
-	peer, err := webrtc.NewPeerConnection(pair.conf)
-	if err != nil {
-		logrus.Error(err)
-		return err
-	}
-	dc, err := peer.CreateDataChannel("data", nil)
+	// remote finds out pool id and impl of the pair by label,
+	// responsers before status messages ignore the protocol
+	protocol := statusProtocol
+	dc, err := pair.peer.CreateDataChannel(pair.poolId.String(CONNECTION_DRECT_OUT), &webrtc.DataChannelInit{Protocol: &protocol})
 	if err != nil {
 		pair.Close()
 		return err
 	}
+	pair.dc = dc
+	pair.writer = &Wrapper{DataChannel: dc, peer: pair.peer}
+	pair.peer.addPair(pair.poolId.String(pair.Direction()), pair)
 	go func() {
 		for !pair.IsReady() {
 			time.Sleep(100 * time.Millisecond)
@@ -129,29 +197,35 @@ func (pair *WebRTC) Dial() error {
 		if err != nil {
 			logrus.Error(err)
 			pair.Exit <- err
-			dc.Close()
 			pair.Close()
 		}
 	}()
 	dc.OnOpen(func() {
 		logrus.Info("data channel open 1")
-		pair.Exit <- nil
+		// remote is known once open, ready after its status if it sends one
+		if !pair.peer.SendsStatus() {
+			pair.Exit <- nil
+		}
 		pair.Ready()
 		// hangs
-		n, err := io.Copy(&Wrapper{dc}, pair.impl.Reader())
+		n, err := io.Copy(pair.writer, pair.impl.Reader())
 		if err != nil {
 			logrus.Error(err)
 		}
+		pair.writer.Flush()
 		for dc.BufferedAmount() > 0 {
 			time.Sleep(100 * time.Millisecond)
 		}
 		logrus.Info("trans1 ", n, err)
 		pair.Exit <- err
-		dc.Close()
 		pair.Close()
 		logrus.Info("data channel close 1")
 	})
 	dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+		if msg.IsString && pair.peer.SendsStatus() {
+			pair.readStatus(msg.Data)
+			return
+		}
 		if pair.impl == nil {
 			pair.Close()
 			return
@@ -168,108 +242,49 @@ func (pair *WebRTC) Dial() error {
 		pair.Close()
 		logrus.Debug("data channel closed")
 	})
-	pair.PeerConnection = peer
 	return nil
 }
+
+// close data channel of pair, peer connection is kept for other pairs
 func (pair *WebRTC) Close() {
-	if pair.PeerConnection != nil {
-		pair.PeerConnection.Close()
+	pair.closeOnce.Do(func() {
+		if pair.dc != nil {
+			pair.dc.Close()
+		}
+		if pair.peer != nil {
+			pair.peer.removePair(pair.poolId.String(pair.Direction()))
+		}
 		pair.impl.Close()
 		(*pair.stmChan) <- CleanRequest{pair.poolId.String(pair.Direction()), pair.Name()}
-	}
-}
-
-func (pair *WebRTC) Offer(target string, reType int32) (types.SignalingInfo, error) {
-	var info types.SignalingInfo
-	logrus.Debug("pair offer")
-	if target == "" {
-		return info, fmt.Errorf("target was empty")
-	}
-	offer, err := pair.PeerConnection.CreateOffer(nil)
-	if err != nil {
-		pair.Close()
-		return info, err
-	}
-	if err = pair.PeerConnection.SetLocalDescription(offer); err != nil {
-		pair.Close()
-		return info, err
-	}
-	ret := types.SignalingInfo{
-		Id:                pair.poolId,
-		Flag:              types.SIG_TYPE_OFFER,
-		Target:            pair.targetId,
-		SDP:               offer.SDP,
-		RemoteRequestType: reType,
-		Source:            pair.nodeId,
-	}
-	return ret, nil
+	})
 }
 
-func (pair *WebRTC) Anwser(info types.SignalingInfo) (types.SignalingInfo, error) {
-	logrus.Debug("pair anwser")
-	if err := pair.PeerConnection.SetRemoteDescription(webrtc.SessionDescription{
-		Type: webrtc.SDPTypeOffer,
-		SDP:  info.SDP,
-	}); err != nil {
-		pair.Close()
-		return info, err
-	}
-	answer, err := pair.PeerConnection.CreateAnswer(nil)
+// readStatus reports status of responser to dialer, the pair is closed if not ready
+func (pair *WebRTC) readStatus(data []byte) {
+	var st pairStatus
+	err := json.Unmarshal(data, &st)
 	if err != nil {
+		pair.Exit <- fmt.Errorf("bad status from %s: %v", pair.TargetId(), err)
 		pair.Close()
-		return info, err
-	}
-
-	err = pair.PeerConnection.SetLocalDescription(answer)
-	if err != nil {
-		logrus.Error(err)
-		pair.Close()
-		return info, err
-	}
-	ret := types.SignalingInfo{
-		Id:     info.Id,
-		Flag:   types.SIG_TYPE_ANSWER,
-		SDP:    answer.SDP,
-		Target: pair.targetId,
-		Source: pair.nodeId,
-	}
-	return ret, nil
-}
-
-func (pair *WebRTC) MakeConnection(info types.SignalingInfo) error {
-	logrus.Debug("pair make connection")
-	if pair == nil || pair.PeerConnection == nil {
-		return fmt.Errorf("invalid peer connection")
+		return
 	}
-	if err := pair.PeerConnection.SetRemoteDescription(webrtc.SessionDescription{
-		Type: webrtc.SDPTypeAnswer,
-		SDP:  info.SDP,
-	}); err != nil {
-		logrus.Error("make connection rtc error: ", pair.poolId.String(pair.Direction()), " ", err)
-		pair.Close()
-		return err
+	if st.Code == types.STATUS_OK {
+		pair.Exit <- nil
+		return
 	}
-	pair.Exit <- nil
-	return nil
+	logrus.Warn("rejected by ", pair.TargetId(), ": ", st.Message)
+	pair.Exit <- types.NewError(st.Code, "rejected by %s: %s", pair.TargetId(), st.Message)
+	pair.Close()
 }
 
-func (pair *WebRTC) AddCandidate(ca *webrtc.ICECandidateInit, id types.PoolId) error {
-	if pair != nil && id.Raw() == pair.PoolId().Raw() {
-		if !pair.IsRemoteDescriptionSet() {
-			logrus.Warn("waiting remote description be set ", pair.poolId.String(pair.Direction()))
-			return fmt.Errorf("remote description NOT set")
-		}
-		err := pair.PeerConnection.AddICECandidate(*ca)
-		if err != nil {
-			logrus.Error(err, pair.PoolId(), id)
-			return err
-		}
-	} else {
-		return fmt.Errorf("dismatched candidate id")
+// remote refused the offer
+func (pair *WebRTC) Reject(info types.SignalingInfo) {
+	logrus.Warn("rejected by ", info.Source, ": ", info.Message)
+	code := info.Code
+	// remotes before status codes leave it empty
+	if code == types.STATUS_OK {
+		code = types.STATUS_FAILED
 	}
-	return nil
-}
-
-func (pair *WebRTC) IsRemoteDescriptionSet() bool {
-	return !(pair.PeerConnection.RemoteDescription() == nil)
+	pair.Exit <- types.NewError(code, "rejected by %s: %s", info.Source, info.Message)
+	pair.Close()
 }
@@ -0,0 +1,292 @@
+package conn
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/sirupsen/logrus"
+	"github.com/suutaku/sshx/pkg/types"
+)
+
+const (
+	// close peer connection if no pair used it for a while
+	peerIdleTimeout = 10 * time.Minute
+	// give up if ice restart not done in time
+	iceRestartTimeout  = time.Minute
+	iceRestartInterval = 10 * time.Second
+)
+
+// webrtcPeer is a peer connection to a remote node, shared by pairs of the node.
+// Every pair owns a data channel labelled with its pool id.
+type webrtcPeer struct {
+	*webrtc.PeerConnection
+	remoteId string
+	// pool id of the pair which negotiated this peer connection
+	id types.PoolId
+	// offerer restarts ice when connection broken
+	offerer   bool
+	pairs     map[string]*WebRTC
+	pending   []webrtc.ICECandidateInit
+	idleTimer *time.Timer
+	// closed when recovered or given up, nil if not recovering
+	recovering    chan struct{}
+	recoveryTimer *time.Timer
+	lock          sync.Mutex
+	// remote sends status on data channels of statusProtocol
+	sendsStatus bool
+}
+
+func newWebRTCPeer(conf webrtc.Configuration, remoteId string, id types.PoolId, offerer bool) (*webrtcPeer, error) {
+	pc, err := webrtc.NewPeerConnection(conf)
+	if err != nil {
+		return nil, err
+	}
+	return &webrtcPeer{
+		PeerConnection: pc,
+		remoteId:       remoteId,
+		id:             id,
+		offerer:        offerer,
+		pairs:          make(map[string]*WebRTC),
+	}, nil
+}
+
+// IsHealthy reports whether new data channels can be opened on this peer connection
+func (peer *webrtcPeer) IsHealthy() bool {
+	switch peer.ConnectionState() {
+	case webrtc.PeerConnectionStateNew, webrtc.PeerConnectionStateConnecting, webrtc.PeerConnectionStateConnected:
+		return true
+	}
+	return false
+}
+
+func (peer *webrtcPeer) addPair(key string, pair *WebRTC) {
+	peer.lock.Lock()
+	defer peer.lock.Unlock()
+	peer.pairs[key] = pair
+	if peer.idleTimer != nil {
+		peer.idleTimer.Stop()
+		peer.idleTimer = nil
+	}
+}
+
+func (peer *webrtcPeer) removePair(key string) {
+	peer.lock.Lock()
+	defer peer.lock.Unlock()
+	delete(peer.pairs, key)
+	if len(peer.pairs) > 0 || peer.idleTimer != nil {
+		return
+	}
+	peer.idleTimer = time.AfterFunc(peerIdleTimeout, func() {
+		peer.lock.Lock()
+		idle := len(peer.pairs) == 0
+		peer.lock.Unlock()
+		if idle {
+			logrus.Debug("close idle peer connection to ", peer.remoteId)
+			peer.Close()
+		}
+	})
+}
+
+// fail all pairs on this peer connection, used when peer connection broken
+func (peer *webrtcPeer) failPairs(err error) {
+	peer.lock.Lock()
+	pairs := make([]*WebRTC, 0, len(peer.pairs))
+	for _, v := range peer.pairs {
+		pairs = append(pairs, v)
+	}
+	peer.lock.Unlock()
+	for _, v := range pairs {
+		v.Exit <- err
+		v.Close()
+	}
+}
+
+// IsRecovering reports whether ice restart is in progress
+func (peer *webrtcPeer) IsRecovering() bool {
+	peer.lock.Lock()
+	defer peer.lock.Unlock()
+	return peer.recovering != nil
+}
+
+// startRecovery marks peer connection recovering, returns false if already started.
+// onTimeout is called if not recovered in time.
+func (peer *webrtcPeer) startRecovery(onTimeout func()) bool {
+	peer.lock.Lock()
+	defer peer.lock.Unlock()
+	if peer.recovering != nil {
+		return false
+	}
+	ch := make(chan struct{})
+	peer.recovering = ch
+	peer.recoveryTimer = time.AfterFunc(iceRestartTimeout, func() {
+		if peer.stopRecovery(ch) {
+			onTimeout()
+		}
+	})
+	return true
+}
+
+// stopRecovery ends the recovery of ch, returns false if it was ended already
+func (peer *webrtcPeer) stopRecovery(ch chan struct{}) bool {
+	peer.lock.Lock()
+	defer peer.lock.Unlock()
+	if ch == nil || peer.recovering != ch {
+		return false
+	}
+	peer.recoveryTimer.Stop()
+	peer.recovering = nil
+	close(ch)
+	return true
+}
+
+// endRecovery ends current recovery, returns false if not recovering
+func (peer *webrtcPeer) endRecovery() bool {
+	peer.lock.Lock()
+	ch := peer.recovering
+	peer.lock.Unlock()
+	return peer.stopRecovery(ch)
+}
+
+// recovered ends recovery and sends data kept by pairs during recovery
+func (peer *webrtcPeer) recovered() {
+	if !peer.endRecovery() {
+		return
+	}
+	logrus.Info("peer connection to ", peer.remoteId, " recovered")
+	peer.lock.Lock()
+	defer peer.lock.Unlock()
+	for _, v := range peer.pairs {
+		if v.writer != nil {
+			go v.writer.Flush()
+		}
+	}
+}
+
+// waitRecovered blocks until recovery ended
+func (peer *webrtcPeer) waitRecovered() {
+	peer.lock.Lock()
+	ch := peer.recovering
+	peer.lock.Unlock()
+	if ch != nil {
+		<-ch
+	}
+}
+
+func (peer *webrtcPeer) setRemoteDescription(desc webrtc.SessionDescription) error {
+	peer.lock.Lock()
+	defer peer.lock.Unlock()
+	if err := peer.SetRemoteDescription(desc); err != nil {
+		return err
+	}
+	for _, v := range peer.pending {
+		if err := peer.AddICECandidate(v); err != nil {
+			logrus.Error(err)
+		}
+	}
+	peer.pending = nil
+	return nil
+}
+
+// AddCandidate adds a remote candidate, candidates arrived before remote description are cached
+func (peer *webrtcPeer) AddCandidate(ca webrtc.ICECandidateInit) error {
+	peer.lock.Lock()
+	defer peer.lock.Unlock()
+	// candidates for a pending offer wait for its answer
+	if peer.RemoteDescription() == nil || peer.SignalingState() == webrtc.SignalingStateHaveLocalOffer {
+		peer.pending = append(peer.pending, ca)
+		return nil
+	}
+	return peer.AddICECandidate(ca)
+}
+
+func (peer *webrtcPeer) Offer(source string, reType int32) (types.SignalingInfo, error) {
+	logrus.Debug("peer offer")
+	return peer.offer(source, reType, types.SIG_TYPE_OFFER, nil)
+}
+
+// Restart creates an offer with new ice credentials
+func (peer *webrtcPeer) Restart(source string) (types.SignalingInfo, error) {
+	logrus.Debug("peer restart ice")
+	return peer.offer(source, 0, types.SIG_TYPE_RESTART, &webrtc.OfferOptions{ICERestart: true})
+}
+
+func (peer *webrtcPeer) offer(source string, reType int32, flag int, options *webrtc.OfferOptions) (types.SignalingInfo, error) {
+	var info types.SignalingInfo
+	offer, err := peer.CreateOffer(options)
+	if err != nil {
+		return info, err
+	}
+	if err = peer.SetLocalDescription(offer); err != nil {
+		return info, err
+	}
+	ret := types.SignalingInfo{
+		Id:                peer.id,
+		Flag:              flag,
+		Target:            peer.remoteId,
+		SDP:               offer.SDP,
+		RemoteRequestType: reType,
+		Source:            source,
+		Message:           statusProtocol,
+	}
+	return ret, nil
+}
+
+func (peer *webrtcPeer) Anwser(info types.SignalingInfo) (types.SignalingInfo, error) {
+	logrus.Debug("peer anwser")
+	peer.setSendsStatus(info)
+	err := peer.setRemoteDescription(webrtc.SessionDescription{
+		Type: webrtc.SDPTypeOffer,
+		SDP:  info.SDP,
+	})
+	if err != nil {
+		return info, err
+	}
+	answer, err := peer.CreateAnswer(nil)
+	if err != nil {
+		return info, err
+	}
+	err = peer.SetLocalDescription(answer)
+	if err != nil {
+		return info, err
+	}
+	ret := types.SignalingInfo{
+		Id:     info.Id,
+		Flag:   types.SIG_TYPE_ANSWER,
+		SDP:    answer.SDP,
+		Target: info.Source,
+		Source: info.Target,
+		// offerer learns this side sends status
+		Message: statusProtocol,
+	}
+	return ret, nil
+}
+
+func (peer *webrtcPeer) MakeConnection(info types.SignalingInfo) error {
+	logrus.Debug("peer make connection")
+	peer.setSendsStatus(info)
+	err := peer.setRemoteDescription(webrtc.SessionDescription{
+		Type: webrtc.SDPTypeAnswer,
+		SDP:  info.SDP,
+	})
+	if err != nil {
+		return fmt.Errorf("make connection rtc error: %s %v", peer.id.String(CONNECTION_DRECT_OUT), err)
+	}
+	return nil
+}
+
+// setSendsStatus learns from offer or answer of remote whether it sends status,
+// remotes before status messages leave Message empty
+func (peer *webrtcPeer) setSendsStatus(info types.SignalingInfo) {
+	peer.lock.Lock()
+	defer peer.lock.Unlock()
+	peer.sendsStatus = info.Message == statusProtocol
+}
+
+// SendsStatus reports whether data channels can ask remote for status
+func (peer *webrtcPeer) SendsStatus() bool {
+	peer.lock.Lock()
+	defer peer.lock.Unlock()
+	return peer.sendsStatus
+}
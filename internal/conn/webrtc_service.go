@@ -2,34 +2,75 @@ package conn
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/gob"
 	"fmt"
 	"net"
-	"net/http"
-	"path"
+	"sync"
 	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/pion/webrtc/v3"
 	"github.com/sirupsen/logrus"
+	"github.com/suutaku/sshx/internal/signaling"
+	"github.com/suutaku/sshx/pkg/conf"
 	"github.com/suutaku/sshx/pkg/impl"
 	"github.com/suutaku/sshx/pkg/types"
 )
 
+const (
+	// signaling info older than this will be dropped
+	signatureMaxAge = 5 * time.Minute
+	// server pings stream every few seconds
+	signalingReadTimeout   = 30 * time.Second
+	signalingRetryInterval = 1 * time.Second
+	heartbeatInterval      = 10 * time.Second
+	candidateWaitRetries   = 50
+	// data channel of a rejected pair is closed after reject reached dialer
+	rejectGrace = 2 * time.Second
+	// retry of failed TURN credential fetches, doubled on each failure
+	turnMinBackoff = 30 * time.Second
+	turnMaxBackoff = 10 * time.Minute
+)
+
 type WebRTCService struct {
 	BaseConnectionService
-	sigPull             chan types.SignalingInfo
-	sigPush             chan types.SignalingInfo
-	conf                webrtc.Configuration
-	signalingServerAddr string
+	sigPull     chan types.SignalingInfo
+	sigPush     chan types.SignalingInfo
+	confManager *conf.ConfManager
+	identity    *conf.Identity
+	sigClient   *signaling.Client
+	// peer connections by negotiation id
+	peers    map[int64]*webrtcPeer
+	peerLock sync.Mutex
+	// TURN credential from signaling server, renewed at half of its ttl,
+	// failed fetches are retried after turnBackoff
+	turnCred     *types.TURNCredential
+	turnRenewAt  time.Time
+	turnExpireAt time.Time
+	turnBackoff  time.Duration
+	turnFetching bool
+	turnLock     sync.Mutex
+	// digests of accepted signaling info until too old to pass verifying, replays are dropped
+	accepted     map[[sha256.Size]byte]time.Time
+	acceptedLock sync.Mutex
 }
 
-func NewWebRTCService(id, signalingServerAddr string, conf webrtc.Configuration) *WebRTCService {
+func NewWebRTCService(cm *conf.ConfManager) *WebRTCService {
+	identity, err := cm.Identity()
+	if err != nil {
+		logrus.Error("cannot load node identity: ", err)
+	}
 	return &WebRTCService{
 		sigPull:               make(chan types.SignalingInfo, 128),
 		sigPush:               make(chan types.SignalingInfo, 128),
-		conf:                  conf,
-		signalingServerAddr:   signalingServerAddr,
-		BaseConnectionService: *NewBaseConnectionService(id),
+		confManager:           cm,
+		identity:              identity,
+		sigClient:             signaling.NewClient(cm),
+		peers:                 make(map[int64]*webrtcPeer),
+		accepted:              make(map[[sha256.Size]byte]time.Time),
+		BaseConnectionService: *NewBaseConnectionService(cm.Conf().ID),
 	}
 }
 
@@ -41,8 +82,8 @@ func (wss *WebRTCService) Start() error {
 	return nil
 }
 
-func (wss *WebRTCService) CreateConnection(sender *impl.Sender, sock net.Conn, poolId types.PoolId) error {
-	err := wss.BaseConnectionService.CreateConnection(sender, sock, poolId)
+func (wss *WebRTCService) CreateConnection(ctx context.Context, sender *impl.Sender, sock net.Conn, poolId types.PoolId) error {
+	err := wss.BaseConnectionService.CreateConnection(ctx, sender, sock, poolId)
 	if err != nil {
 		return err
 	}
@@ -54,49 +95,297 @@ func (wss *WebRTCService) CreateConnection(sender *impl.Sender, sock net.Conn, p
 		iface.SetConn(sock)
 	}
 
-	pair := NewWebRTC(wss.conf, iface, wss.id, iface.HostId(), poolId, CONNECTION_DRECT_OUT, &wss.CleanChan)
-	if pair == nil {
-		return fmt.Errorf("cannot create pair")
+	if !iface.IsNeedConnect() {
+		logrus.Error("NOT create connection for ", impl.GetImplName(iface.Code()))
+		pair := NewWebRTC(nil, iface, wss.id, iface.HostId(), poolId, CONNECTION_DRECT_OUT, &wss.CleanChan)
+		logrus.Debug("ready to put piar ", pair.poolId.String(pair.Direction()))
+		return wss.AddPair(pair)
+	}
+
+	// open a new data channel on existed peer connection if possible
+	peer := wss.getPeer(iface.HostId())
+	negotiate := peer == nil
+	if negotiate {
+		peer, err = wss.newPeer(iface.HostId(), poolId, true)
+		if err != nil {
+			return err
+		}
+	} else {
+		logrus.Debug("reuse peer connection ", peer.id.String(CONNECTION_DRECT_OUT), " to ", iface.HostId())
 	}
+	pair := NewWebRTC(peer, iface, wss.id, iface.HostId(), poolId, CONNECTION_DRECT_OUT, &wss.CleanChan)
 
-	err = pair.Dial()
+	logrus.Debug("ready to put piar ", pair.poolId.String(pair.Direction()))
+	err = wss.AddPair(pair)
+	if err != nil {
+		if negotiate {
+			peer.Close()
+		}
+		return err
+	}
+	err = pair.Dial(ctx)
 	if err != nil {
 		return err
 	}
-	if iface.IsNeedConnect() {
+	if negotiate {
 		logrus.Debug("create connection for ", impl.GetImplName(iface.Code()))
-		info, err := pair.Offer(string(iface.HostId()), sender.Type)
+		info, err := peer.Offer(wss.id, sender.Type)
 		if err != nil {
+			peer.Close()
 			return err
 		}
-		pair.PeerConnection.OnICECandidate(func(c *webrtc.ICECandidate) {
-			// set condiate pool it direction to in for server
-			if wss.GetPair(info.Id.String(pair.Direction())) == nil {
-				return
-			}
-			info.Id.Direction = pair.Direction()
-			wss.SignalCandidate(info, info.Target, c)
-		})
 		err = wss.push(info)
 		if err != nil {
-			sock.Close()
+			peer.Close()
 			return err
 		}
-	} else {
-		logrus.Error("NOT create connection for ", impl.GetImplName(iface.Code()))
 	}
 
-	logrus.Debug("ready to put piar ", pair.poolId.String(pair.Direction()))
+	if !sender.Detach {
+		logrus.Debug("waitting pair send exit message")
+		select {
+		case err = <-pair.Exit:
+		case <-ctx.Done():
+			// closing data channel and new peer connection tears down remote half
+			logrus.Debug("abort pair ", pair.poolId.String(pair.Direction()), ": ", ctx.Err())
+			err = setupError(ctx, peer, negotiate)
+			pair.Close()
+			if negotiate {
+				peer.Close()
+			}
+			return err
+		}
+		logrus.Debug("pair send exit message")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setupError tells why a pair was not ready before ctx done
+func setupError(ctx context.Context, peer *webrtcPeer, negotiate bool) error {
+	if ctx.Err() != context.DeadlineExceeded {
+		return ctx.Err()
+	}
+	if negotiate && peer.RemoteDescription() == nil {
+		return types.NewError(types.STATUS_PEER_OFFLINE, "no answer from %s", peer.remoteId)
+	}
+	if peer.ICEConnectionState() != webrtc.ICEConnectionStateConnected {
+		return types.NewError(types.STATUS_ICE_FAILED, "ice connection to %s %s", peer.remoteId, peer.ICEConnectionState())
+	}
+	return ctx.Err()
+}
+
+// create a peer connection to remote, data channels opened by remote are served as responser
+func (wss *WebRTCService) newPeer(remoteId string, id types.PoolId, offerer bool) (*webrtcPeer, error) {
+	peer, err := newWebRTCPeer(wss.rtcConfig(), remoteId, id, offerer)
+	if err != nil {
+		return nil, err
+	}
+	peer.OnICECandidate(func(c *webrtc.ICECandidate) {
+		wss.SignalCandidate(types.SignalingInfo{Id: id}, remoteId, c)
+	})
+	peer.OnDataChannel(func(dc *webrtc.DataChannel) {
+		wss.serveDataChannel(peer, dc)
+	})
+	peer.OnICEConnectionStateChange(func(state webrtc.ICEConnectionState) {
+		logrus.Debug("ice connection to ", remoteId, " ", state)
+		switch state {
+		case webrtc.ICEConnectionStateDisconnected, webrtc.ICEConnectionStateFailed:
+			wss.recoverPeer(peer)
+		case webrtc.ICEConnectionStateConnected:
+			peer.recovered()
+		}
+	})
+	peer.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		logrus.Debug("peer connection to ", remoteId, " ", state)
+		if state == webrtc.PeerConnectionStateClosed {
+			wss.closePeer(peer, fmt.Errorf("peer connection %s", state))
+		}
+	})
+	wss.peerLock.Lock()
+	wss.peers[id.Raw()] = peer
+	wss.peerLock.Unlock()
+	return peer, nil
+}
+
+// rtcConfig returns configure of new peer connections,
+// with TURN servers from signaling server if it issues credentials
+func (wss *WebRTCService) rtcConfig() webrtc.Configuration {
+	ret := wss.confManager.Conf().RTCConf
+	ret.ICEServers = append([]webrtc.ICEServer{}, ret.ICEServers...)
+
+	cred := wss.turnCredential()
+	if cred != nil && len(cred.URIs) > 0 {
+		ret.ICEServers = append(ret.ICEServers, webrtc.ICEServer{
+			URLs:           cred.URIs,
+			Username:       cred.Username,
+			Credential:     cred.Password,
+			CredentialType: webrtc.ICECredentialTypePassword,
+		})
+	}
+	return ret
+}
+
+// turnCredential returns cached TURN credential, fetches it if due.
+// Only one caller fetches at a time, others take the cached one.
+func (wss *WebRTCService) turnCredential() *types.TURNCredential {
+	wss.turnLock.Lock()
+	if wss.turnFetching || time.Now().Before(wss.turnRenewAt) {
+		defer wss.turnLock.Unlock()
+		return wss.validTURNCredential()
+	}
+	wss.turnFetching = true
+	wss.turnLock.Unlock()
+
+	cred, err := wss.sigClient.TURN(wss.id)
+
+	wss.turnLock.Lock()
+	defer wss.turnLock.Unlock()
+	wss.turnFetching = false
+	if err != nil {
+		wss.turnBackoff = min(max(2*wss.turnBackoff, turnMinBackoff), turnMaxBackoff)
+		wss.turnRenewAt = time.Now().Add(wss.turnBackoff)
+		logrus.Debug("no TURN credential, retry in ", wss.turnBackoff, ": ", err)
+		return wss.validTURNCredential()
+	}
+	ttl := time.Duration(cred.TTL) * time.Second
+	wss.turnCred = cred
+	wss.turnBackoff = 0
+	wss.turnRenewAt = time.Now().Add(ttl / 2)
+	wss.turnExpireAt = time.Now().Add(ttl)
+	return cred
+}
+
+// cached TURN credential if not expired, turnLock must be held
+func (wss *WebRTCService) validTURNCredential() *types.TURNCredential {
+	if wss.turnCred != nil && time.Now().After(wss.turnExpireAt) {
+		wss.turnCred = nil
+	}
+	return wss.turnCred
+}
+
+// drop peer connection and its pairs
+func (wss *WebRTCService) closePeer(peer *webrtcPeer, reason error) {
+	wss.peerLock.Lock()
+	delete(wss.peers, peer.id.Raw())
+	wss.peerLock.Unlock()
+	peer.endRecovery()
+	peer.failPairs(reason)
+	peer.Close()
+}
+
+// recoverPeer restarts ice of a broken peer connection, pairs keep their data until recovered.
+// Only offerer restarts to avoid glare, answerer waits for the restart offer.
+func (wss *WebRTCService) recoverPeer(peer *webrtcPeer) {
+	started := peer.startRecovery(func() {
+		logrus.Warn("cannot recover peer connection to ", peer.remoteId)
+		wss.closePeer(peer, types.NewError(types.STATUS_ICE_FAILED, "peer connection to %s broken", peer.remoteId))
+	})
+	if !started || !peer.offerer {
+		return
+	}
+	logrus.Warn("peer connection to ", peer.remoteId, " broken, restart ice")
+	go func() {
+		// network may not be ready yet, retry until recovered
+		for peer.IsRecovering() {
+			info, err := peer.Restart(wss.id)
+			if err != nil {
+				logrus.Error(err)
+			} else {
+				wss.push(info)
+			}
+			time.Sleep(iceRestartInterval)
+		}
+	}()
+}
+
+// getPeer returns a healthy peer connection to remote, connected one first
+func (wss *WebRTCService) getPeer(remoteId string) *webrtcPeer {
+	wss.peerLock.Lock()
+	defer wss.peerLock.Unlock()
+	var ret *webrtcPeer
+	for _, v := range wss.peers {
+		if v.remoteId != remoteId || !v.IsHealthy() {
+			continue
+		}
+		if v.ConnectionState() == webrtc.PeerConnectionStateConnected {
+			return v
+		}
+		ret = v
+	}
+	return ret
+}
+
+// lookupPeer returns peer connection negotiated by info
+func (wss *WebRTCService) lookupPeer(info types.SignalingInfo) *webrtcPeer {
+	wss.peerLock.Lock()
+	defer wss.peerLock.Unlock()
+	peer := wss.peers[info.Id.Raw()]
+	if peer == nil || peer.remoteId != info.Source {
+		return nil
+	}
+	return peer
+}
+
+// check if remote can request impl on this device
+func (wss *WebRTCService) checkRequest(source string, code int32) (impl.Impl, error) {
+	iface := impl.GetImpl(code)
+	if iface == nil {
+		return nil, types.NewError(types.STATUS_IMPL_UNKNOWN, "unknown impl code %d", code)
+	}
+	if !wss.confManager.Conf().ACL.IsAllowed(source, iface.Code()) {
+		logrus.Warn("ACL denied ", impl.GetImplName(iface.Code()), " from ", source)
+		return nil, types.NewError(types.STATUS_ACL_DENIED, "%s not allowed", impl.GetImplName(iface.Code()))
+	}
+	return iface, nil
+}
+
+// create a responser pair for data channel opened by remote
+func (wss *WebRTCService) serveDataChannel(peer *webrtcPeer, dc *webrtc.DataChannel) {
+	poolId, err := types.ParsePoolId(dc.Label())
+	if err != nil {
+		logrus.Error(err)
+		dc.Close()
+		return
+	}
+	iface, err := wss.checkRequest(peer.remoteId, poolId.ImplCode)
+	if err != nil {
+		logrus.Error(err)
+		wss.refuseChannel(peer, dc, *poolId, err, func() { dc.Close() })
+		return
+	}
+	iface.SetHostId(peer.remoteId)
+	pair := NewWebRTC(peer, iface, wss.id, peer.remoteId, *poolId, CONNECTION_DRECT_IN, &wss.CleanChan)
+	pair.dc = dc
+	err = pair.Response()
+	if err != nil {
+		logrus.Error(err)
+		wss.refuseChannel(peer, dc, *poolId, types.NewError(types.STATUS_REMOTE_ERROR, "%v", err), pair.Close)
+		return
+	}
 	err = wss.AddPair(pair)
 	if err != nil {
-		return err
+		logrus.Error(err)
 	}
-	if !sender.Detach {
-		logrus.Warn("waitting pair send exit message")
-		<-pair.Exit
-		logrus.Warn("pair send exit message")
+}
+
+// refuseChannel tells dialer why its data channel is refused, by status if it asked for,
+// by signaling otherwise. The channel is closed later to not race the reason.
+func (wss *WebRTCService) refuseChannel(peer *webrtcPeer, dc *webrtc.DataChannel, poolId types.PoolId, reason error, close func()) {
+	if dc.Protocol() != statusProtocol {
+		wss.reject(types.SignalingInfo{Id: poolId, Source: peer.remoteId}, reason)
+		time.AfterFunc(rejectGrace, close)
+		return
 	}
-	return nil
+	// remote channels open after served
+	dc.OnOpen(func() {
+		if err := sendStatus(dc, reason); err != nil {
+			logrus.Error(err)
+		}
+		time.AfterFunc(rejectGrace, close)
+	})
 }
 
 func (wss *WebRTCService) DestroyConnection(tmp *impl.Sender) error {
@@ -123,6 +412,47 @@ func (wss *WebRTCService) isValidSignalingInfo(input types.SignalingInfo) bool {
 	return true
 }
 
+// verify signature of signaling info with public key of trusted source
+func (wss *WebRTCService) verifySignalingInfo(info types.SignalingInfo) error {
+	if len(info.Signature) == 0 {
+		return fmt.Errorf("unsigned signaling info from %s", info.Source)
+	}
+	age := time.Since(time.Unix(info.Timestamp, 0))
+	if age > signatureMaxAge || age < -signatureMaxAge {
+		return fmt.Errorf("stale signaling info from %s", info.Source)
+	}
+	publicKey := wss.confManager.Conf().TrustedKey(info.Source)
+	if publicKey == "" {
+		return fmt.Errorf("untrusted signaling info from %s", info.Source)
+	}
+	digest := info.Digest()
+	err := conf.VerifySignature(publicKey, digest, info.Signature)
+	if err != nil {
+		return fmt.Errorf("bad signature from %s: %v", info.Source, err)
+	}
+	if !wss.accept(sha256.Sum256(digest), time.Unix(info.Timestamp, 0)) {
+		return fmt.Errorf("replayed signaling info from %s", info.Source)
+	}
+	return nil
+}
+
+// accept records sum of signaling info signed at ts, false if it was accepted before
+func (wss *WebRTCService) accept(sum [sha256.Size]byte, ts time.Time) bool {
+	wss.acceptedLock.Lock()
+	defer wss.acceptedLock.Unlock()
+	now := time.Now()
+	for k, v := range wss.accepted {
+		if now.Sub(v) > signatureMaxAge {
+			delete(wss.accepted, k)
+		}
+	}
+	if _, ok := wss.accepted[sum]; ok {
+		return false
+	}
+	wss.accepted[sum] = ts
+	return true
+}
+
 func (wss *WebRTCService) push(info types.SignalingInfo) error {
 	if !wss.isValidSignalingInfo(info) {
 		return fmt.Errorf("invalid SignalingInfo")
@@ -139,104 +469,185 @@ func (wss *WebRTCService) ServeOfferInfo(info types.SignalingInfo) {
 	cvt := impl.Sender{
 		Type: info.RemoteRequestType,
 	}
-	iface := impl.GetImpl(cvt.GetAppCode())
-	if iface == nil {
-		logrus.Error("unknow impl for IMCODE: ", cvt.GetAppCode())
+	// data channels will be checked again, reject here to let dialer know early
+	_, err := wss.checkRequest(info.Source, cvt.GetAppCode())
+	if err != nil {
+		logrus.Error(err)
+		wss.reject(info, err)
 		return
 	}
-	iface.SetHostId(info.Source)
-	// set candidate pool id direction to out for self(server)
-	pair := NewWebRTC(wss.conf, iface, wss.id, info.Source, info.Id, CONNECTION_DRECT_IN, &wss.CleanChan)
-	// set candidate pool id direction to out for client
-	err := pair.Response()
+	peer, err := wss.newPeer(info.Source, info.Id, false)
 	if err != nil {
 		logrus.Error(err)
 		return
 	}
-	awser, err := pair.Anwser(info)
+	awser, err := peer.Anwser(info)
 	if err != nil {
-		logrus.Error("pair create a nil anwser")
+		logrus.Error("pair create a nil anwser: ", err)
+		peer.Close()
 		return
 	}
-
-	pair.PeerConnection.OnICECandidate(func(c *webrtc.ICECandidate) {
-		logrus.Debug("send candidate")
-		// set candidate pool id direction to out for client
-		info.Id.Direction = pair.Direction()
-		wss.SignalCandidate(info, info.Source, c)
-	})
 	wss.push(awser)
-	err = wss.AddPair(pair)
+}
+
+// answer ice restart of an existed peer connection
+func (wss *WebRTCService) ServeRestartInfo(info types.SignalingInfo) {
+	peer := wss.lookupPeer(info)
+	if peer == nil {
+		logrus.Warn("peer ", info.Id.String(CONNECTION_DRECT_OUT), " was empty, cannot serve restart")
+		return
+	}
+	awser, err := peer.Anwser(info)
 	if err != nil {
 		logrus.Error(err)
 		return
 	}
+	wss.push(awser)
+}
+
+// reject an offer, let dialer know instead of waiting
+func (wss *WebRTCService) reject(info types.SignalingInfo, reason error) {
+	code, msg := types.ErrorStatus(reason)
+	wss.push(types.SignalingInfo{
+		Id:      info.Id,
+		Flag:    types.SIG_TYPE_REJECT,
+		Target:  info.Source,
+		Source:  wss.id,
+		Message: msg,
+		Code:    code,
+	})
 }
 
 func (wss *WebRTCService) ServePush(info types.SignalingInfo) {
-	buf := bytes.NewBuffer(nil)
-	if err := gob.NewEncoder(buf).Encode(info); err != nil {
-		logrus.Error(err)
+	if wss.identity == nil {
+		logrus.Error("no identity to sign signaling info for ", info.Target)
 		return
 	}
-	resp, err := http.Post(wss.signalingServerAddr+
-		path.Join("/", "push", info.Target), "application/binary", buf)
+	info.Timestamp = time.Now().Unix()
+	info.Signature = wss.identity.Sign(info.Digest())
+	err := wss.sigClient.Push(info)
 	if err != nil {
-		logrus.Error(err)
+		logrus.Errorln("push to ", info.Target, "faild: ", err)
 		return
 	}
-	if resp.StatusCode != http.StatusOK {
-		logrus.Errorln("push to ", info.Target, "faild")
-		return
-	}
-	logrus.Debug(wss.signalingServerAddr +
-		path.Join("/", "push", info.Target))
+	logrus.Debug("pushed to ", info.Target)
 }
 
 func (wss *WebRTCService) ServeCandidateInfo(info types.SignalingInfo) {
-	info.Id.Direction = ^info.Id.Direction & 0x01
-	pair := wss.GetPair(info.Id.String(info.Id.Direction))
-	if pair == nil {
-		logrus.Warn("pair ", info.Id.String(info.Id.Direction), " was empty, cannot serve candidate")
+	peer := wss.lookupPeer(info)
+	// candidates may arrive before offer was served
+	for i := 0; peer == nil && i < candidateWaitRetries; i++ {
+		time.Sleep(100 * time.Millisecond)
+		peer = wss.lookupPeer(info)
+	}
+	if peer == nil {
+		logrus.Warn("peer ", info.Id.String(CONNECTION_DRECT_OUT), " was empty, cannot serve candidate")
 		return
 	}
-	pair.(*WebRTC).AddCandidate(&webrtc.ICECandidateInit{Candidate: string(info.Candidate)}, info.Id)
+	err := peer.AddCandidate(webrtc.ICECandidateInit{Candidate: string(info.Candidate)})
+	if err != nil {
+		logrus.Error(err)
+	}
 }
 
 func (wss *WebRTCService) ServeAnwserInfo(info types.SignalingInfo) {
-	// set candidate pool id direction to out for self(client)
-	pair := wss.GetPair(info.Id.String(CONNECTION_DRECT_OUT))
-	if pair == nil {
-		logrus.Error("pair for id ", info.Id.String(CONNECTION_DRECT_OUT), " was empty, cannot serve anwser")
+	peer := wss.lookupPeer(info)
+	if peer == nil {
+		logrus.Error("peer for id ", info.Id.String(CONNECTION_DRECT_OUT), " was empty, cannot serve anwser")
 		return
 	}
-	err := pair.(*WebRTC).MakeConnection(info)
+	err := peer.MakeConnection(info)
 	if err != nil {
 		logrus.Error(err)
+		peer.Close()
+	}
+}
+
+func (wss *WebRTCService) ServeRejectInfo(info types.SignalingInfo) {
+	pair, ok := wss.GetPair(info.Id.String(CONNECTION_DRECT_OUT)).(*WebRTC)
+	if !ok {
+		logrus.Warn("pair for id ", info.Id.String(CONNECTION_DRECT_OUT), " was empty, cannot serve reject")
+		return
+	}
+	pair.Reject(info)
+	// offer was refused, peer connection will never be answered
+	if peer := wss.lookupPeer(info); peer != nil && peer.RemoteDescription() == nil {
+		peer.Close()
+	}
+}
+
+// legacy pull loop, for signaling servers without websocket
+func (wss *WebRTCService) pollSignaling() {
+	for wss.running {
+		info, err := wss.sigClient.Pull(wss.id)
+		if err != nil {
+			time.Sleep(1 * time.Second)
+			continue
+		}
+		wss.sigPull <- info
+	}
+}
+
+// receive signaling infos from websocket until connection broken
+func (wss *WebRTCService) streamSignaling() error {
+	conn, err := wss.sigClient.DialStream(wss.id)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	logrus.Debug("signaling stream connected")
+	conn.SetReadDeadline(time.Now().Add(signalingReadTimeout))
+	conn.SetPingHandler(func(data string) error {
+		conn.SetReadDeadline(time.Now().Add(signalingReadTimeout))
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	for wss.running {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		conn.SetReadDeadline(time.Now().Add(signalingReadTimeout))
+		var info types.SignalingInfo
+		if err = gob.NewDecoder(bytes.NewBuffer(msg)).Decode(&info); err != nil {
+			logrus.Error(err)
+			continue
+		}
+		wss.sigPull <- info
+	}
+	return nil
+}
+
+// announce self to signaling server periodically
+func (wss *WebRTCService) heartbeat() {
+	for wss.running {
+		err := wss.sigClient.Register(types.PeerInfo{
+			ID:      wss.id,
+			Name:    wss.confManager.Conf().DeviceName(),
+			Version: types.Version,
+			Impls:   impl.RegisteredCodes(),
+		})
+		if err != nil {
+			logrus.Debug("register to signaling server: ", err)
+		}
+		time.Sleep(heartbeatInterval)
 	}
 }
 
 func (wss *WebRTCService) ServeSignaling() {
 
-	// pull loop
+	go wss.heartbeat()
+
+	// prefer websocket stream, fall back to pull api
 	go func() {
 		for wss.running {
-			res, err := http.Get(wss.signalingServerAddr +
-				path.Join("/", "pull", wss.id))
-			if err != nil {
-				time.Sleep(1 * time.Second)
-				continue
-			}
-			var info types.SignalingInfo
-			if err = gob.NewDecoder(res.Body).Decode(&info); err != nil {
-				if err != nil {
-					res.Body.Close()
-					time.Sleep(1 * time.Second)
-					continue
-				}
+			err := wss.streamSignaling()
+			if err == signaling.ErrNoStream {
+				logrus.Warn("signaling server has no stream api, fall back to pull")
+				wss.pollSignaling()
+				return
 			}
-			res.Body.Close()
-			wss.sigPull <- info
+			logrus.Debug("signaling stream broken: ", err)
+			time.Sleep(signalingRetryInterval)
 		}
 	}()
 
@@ -245,6 +656,10 @@ func (wss *WebRTCService) ServeSignaling() {
 		case info := <-wss.sigPush:
 			go wss.ServePush(info)
 		case info := <-wss.sigPull:
+			if err := wss.verifySignalingInfo(info); err != nil {
+				logrus.Warn("reject signaling info: ", err)
+				continue
+			}
 			switch info.Flag {
 			case types.SIG_TYPE_OFFER:
 				// server side
@@ -255,6 +670,12 @@ func (wss *WebRTCService) ServeSignaling() {
 			case types.SIG_TYPE_ANSWER:
 				// client side
 				go wss.ServeAnwserInfo(info)
+			case types.SIG_TYPE_REJECT:
+				// client side
+				go wss.ServeRejectInfo(info)
+			case types.SIG_TYPE_RESTART:
+				// server side
+				go wss.ServeRestartInfo(info)
 			case types.SIG_TYPE_UNKNOWN:
 				logrus.Error("unknow signaling type")
 			}
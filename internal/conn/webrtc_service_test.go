@@ -0,0 +1,172 @@
+package conn
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/suutaku/sshx/internal/signaling"
+	"github.com/suutaku/sshx/pkg/conf"
+	"github.com/suutaku/sshx/pkg/types"
+)
+
+func newTestWebRTCService(t *testing.T) (*WebRTCService, *conf.Identity) {
+	// NewConfManager clears entries of known_hosts in $HOME
+	t.Setenv("HOME", t.TempDir())
+	cm := conf.NewConfManager(t.TempDir())
+	peer, err := conf.LoadIdentity(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cm.AddTrustedPeer("peer", peer.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wss := &WebRTCService{
+		confManager: cm,
+		accepted:    make(map[[sha256.Size]byte]time.Time),
+	}
+	return wss, peer
+}
+
+func signedInfo(id *conf.Identity, source string, ts time.Time) types.SignalingInfo {
+	info := types.SignalingInfo{
+		Flag:      types.SIG_TYPE_OFFER,
+		Source:    source,
+		Target:    "self",
+		Id:        *types.NewPoolId(time.Now().UnixNano(), types.APP_TYPE_SSH),
+		SDP:       "offer",
+		Timestamp: ts.Unix(),
+	}
+	info.Signature = id.Sign(info.Digest())
+	return info
+}
+
+func TestVerifySignalingInfo(t *testing.T) {
+	wss, peer := newTestWebRTCService(t)
+	stranger, err := conf.LoadIdentity(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	cases := []struct {
+		name string
+		info func() types.SignalingInfo
+		ok   bool
+	}{
+		{"fresh", func() types.SignalingInfo { return signedInfo(peer, "peer", now) }, true},
+		{"slightly old", func() types.SignalingInfo { return signedInfo(peer, "peer", now.Add(-time.Minute)) }, true},
+		{"slightly ahead", func() types.SignalingInfo { return signedInfo(peer, "peer", now.Add(time.Minute)) }, true},
+		{"expired", func() types.SignalingInfo { return signedInfo(peer, "peer", now.Add(-signatureMaxAge-time.Minute)) }, false},
+		{"too far ahead", func() types.SignalingInfo { return signedInfo(peer, "peer", now.Add(signatureMaxAge+time.Minute)) }, false},
+		{"unsigned", func() types.SignalingInfo {
+			info := signedInfo(peer, "peer", now)
+			info.Signature = nil
+			return info
+		}, false},
+		{"tampered sdp", func() types.SignalingInfo {
+			info := signedInfo(peer, "peer", now)
+			info.SDP = "other offer"
+			return info
+		}, false},
+		{"tampered timestamp", func() types.SignalingInfo {
+			info := signedInfo(peer, "peer", now.Add(-signatureMaxAge-time.Minute))
+			info.Timestamp = now.Unix()
+			return info
+		}, false},
+		{"untrusted source", func() types.SignalingInfo { return signedInfo(stranger, "stranger", now) }, false},
+		{"signed by other key", func() types.SignalingInfo { return signedInfo(stranger, "peer", now) }, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := wss.verifySignalingInfo(c.info())
+			if (err == nil) != c.ok {
+				t.Errorf("verifySignalingInfo() error = %v, want ok %v", err, c.ok)
+			}
+		})
+	}
+}
+
+func TestVerifySignalingInfoReplay(t *testing.T) {
+	wss, peer := newTestWebRTCService(t)
+	info := signedInfo(peer, "peer", time.Now())
+	if err := wss.verifySignalingInfo(info); err != nil {
+		t.Fatal(err)
+	}
+	if err := wss.verifySignalingInfo(info); err == nil {
+		t.Error("replayed signaling info accepted")
+	}
+	// another candidate of the same negotiation in the same second
+	next := info
+	next.Flag = types.SIG_TYPE_CANDIDATE
+	next.Candidate = []byte("candidate")
+	next.Signature = peer.Sign(next.Digest())
+	if err := wss.verifySignalingInfo(next); err != nil {
+		t.Errorf("new signaling info rejected: %v", err)
+	}
+}
+
+func TestTURNCredential(t *testing.T) {
+	wss, _ := newTestWebRTCService(t)
+	var requests atomic.Int32
+	var fail atomic.Bool
+	fail.Store(true)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if fail.Load() {
+			http.Error(w, "no TURN", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(types.TURNCredential{URIs: []string{"turn:a:3478"}, Username: "u", Password: "p", TTL: 3600})
+	}))
+	defer srv.Close()
+	if err := wss.confManager.SetValue("SignalingServerAddr", srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	wss.sigClient = signaling.NewClient(wss.confManager)
+
+	// failure is cached until backoff
+	for i := 0; i < 3; i++ {
+		if cred := wss.turnCredential(); cred != nil {
+			t.Fatalf("credential %v from failing server", cred)
+		}
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("%d requests after failure, want 1", n)
+	}
+	if wss.turnBackoff != turnMinBackoff {
+		t.Errorf("backoff = %v, want %v", wss.turnBackoff, turnMinBackoff)
+	}
+	wss.turnRenewAt = time.Time{}
+	wss.turnCredential()
+	if wss.turnBackoff != 2*turnMinBackoff {
+		t.Errorf("backoff = %v, want %v", wss.turnBackoff, 2*turnMinBackoff)
+	}
+
+	fail.Store(false)
+	wss.turnRenewAt = time.Time{}
+	cred := wss.turnCredential()
+	if cred == nil || cred.Username != "u" {
+		t.Fatalf("credential = %v", cred)
+	}
+	if wss.turnBackoff != 0 {
+		t.Errorf("backoff = %v after success", wss.turnBackoff)
+	}
+	// renewal fails, credential is kept until it expires
+	fail.Store(true)
+	wss.turnRenewAt = time.Time{}
+	if got := wss.turnCredential(); got != cred {
+		t.Errorf("credential = %v after failed renewal, want %v", got, cred)
+	}
+	wss.turnExpireAt = time.Now().Add(-time.Second)
+	if got := wss.turnCredential(); got != nil {
+		t.Errorf("expired credential %v", got)
+	}
+	if n := requests.Load(); n != 4 {
+		t.Errorf("%d requests, want 4", n)
+	}
+}
@@ -0,0 +1,245 @@
+package discovery
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DNS-SD service type of sshx daemons, instances are named by node id
+const serviceName = "_sshx._tcp.local."
+
+const recordTTL = 120
+
+var mdnsAddr = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// Record is a daemon found on LAN
+type Record struct {
+	ID   string
+	Name string
+	IP   net.IP
+	Port int
+}
+
+// Addr returns host:port of direct service
+func (r *Record) Addr() string {
+	return net.JoinHostPort(r.IP.String(), fmt.Sprint(r.Port))
+}
+
+func instanceName(id string) string {
+	return id + "." + serviceName
+}
+
+func hostName(id string) string {
+	return id + ".local."
+}
+
+// Advertiser answers mDNS queries for node id, name and direct port of this daemon
+type Advertiser struct {
+	id   string
+	name string
+	port int
+	conn *net.UDPConn
+	lock sync.Mutex
+}
+
+func NewAdvertiser(id, name string, port int) *Advertiser {
+	return &Advertiser{
+		id:   id,
+		name: name,
+		port: port,
+	}
+}
+
+func (ad *Advertiser) Start() error {
+	conn, err := net.ListenMulticastUDP("udp4", nil, mdnsAddr)
+	if err != nil {
+		return err
+	}
+	ad.lock.Lock()
+	ad.conn = conn
+	ad.lock.Unlock()
+	logrus.Debug("advertise ", instanceName(ad.id), " on port ", ad.port)
+	go ad.serve(conn)
+	return nil
+}
+
+func (ad *Advertiser) Stop() {
+	ad.lock.Lock()
+	defer ad.lock.Unlock()
+	if ad.conn != nil {
+		ad.conn.Close()
+		ad.conn = nil
+	}
+}
+
+func (ad *Advertiser) serve(conn *net.UDPConn) {
+	buf := make([]byte, 9000)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			logrus.Debug("mdns advertiser stopped: ", err)
+			return
+		}
+		var msg dnsmessage.Message
+		if err := msg.Unpack(buf[:n]); err != nil || msg.Header.Response {
+			continue
+		}
+		if !ad.matches(msg.Questions) {
+			continue
+		}
+		reply, err := ad.response(msg)
+		if err != nil {
+			logrus.Error(err)
+			continue
+		}
+		// legacy unicast query expects answer on its port
+		dst := mdnsAddr
+		if src.Port != mdnsAddr.Port {
+			dst = src
+		}
+		if _, err := conn.WriteToUDP(reply, dst); err != nil {
+			logrus.Debug("mdns reply failed: ", err)
+		}
+	}
+}
+
+func (ad *Advertiser) matches(questions []dnsmessage.Question) bool {
+	for _, q := range questions {
+		name := strings.ToLower(q.Name.String())
+		if name == serviceName || name == strings.ToLower(instanceName(ad.id)) || name == strings.ToLower(hostName(ad.id)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ad *Advertiser) response(query dnsmessage.Message) ([]byte, error) {
+	service, err := dnsmessage.NewName(serviceName)
+	if err != nil {
+		return nil, err
+	}
+	instance, err := dnsmessage.NewName(instanceName(ad.id))
+	if err != nil {
+		return nil, err
+	}
+	host, err := dnsmessage.NewName(hostName(ad.id))
+	if err != nil {
+		return nil, err
+	}
+	header := dnsmessage.ResourceHeader{Class: dnsmessage.ClassINET, TTL: recordTTL}
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: query.Header.ID, Response: true, Authoritative: true},
+		Questions: query.Questions,
+	}
+	header.Name = service
+	msg.Answers = append(msg.Answers, dnsmessage.Resource{Header: header, Body: &dnsmessage.PTRResource{PTR: instance}})
+	header.Name = instance
+	msg.Answers = append(msg.Answers,
+		dnsmessage.Resource{Header: header, Body: &dnsmessage.SRVResource{Port: uint16(ad.port), Target: host}},
+		dnsmessage.Resource{Header: header, Body: &dnsmessage.TXTResource{TXT: []string{"id=" + ad.id, "name=" + ad.name}}},
+	)
+	header.Name = host
+	for _, ip := range localIPs() {
+		var a dnsmessage.AResource
+		copy(a.A[:], ip)
+		msg.Answers = append(msg.Answers, dnsmessage.Resource{Header: header, Body: &a})
+	}
+	return msg.Pack()
+}
+
+// ipv4 addresses of interfaces which are up, loopback only if nothing else
+func localIPs() []net.IP {
+	var ret, loopback []net.IP
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	for _, v := range addrs {
+		ipnet, ok := v.(*net.IPNet)
+		if !ok || ipnet.IP.To4() == nil {
+			continue
+		}
+		if ipnet.IP.IsLoopback() {
+			loopback = append(loopback, ipnet.IP.To4())
+		} else {
+			ret = append(ret, ipnet.IP.To4())
+		}
+	}
+	if len(ret) == 0 {
+		return loopback
+	}
+	return ret
+}
+
+// Resolve asks LAN for the daemon of node id, fails if no answer in timeout
+func Resolve(id string, timeout time.Duration) (*Record, error) {
+	instance, err := dnsmessage.NewName(instanceName(id))
+	if err != nil {
+		return nil, err
+	}
+	query := dnsmessage.Message{
+		Questions: []dnsmessage.Question{
+			{Name: instance, Type: dnsmessage.TypeSRV, Class: dnsmessage.ClassINET},
+			{Name: instance, Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET},
+		},
+	}
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if _, err = conn.WriteToUDP(packed, mdnsAddr); err != nil {
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 9000)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return nil, fmt.Errorf("cannot find %s on LAN: %v", id, err)
+		}
+		var msg dnsmessage.Message
+		if err := msg.Unpack(buf[:n]); err != nil || !msg.Header.Response {
+			continue
+		}
+		if rec := parseRecord(id, msg); rec != nil {
+			// answer comes from the address reachable by us
+			rec.IP = src.IP
+			return rec, nil
+		}
+	}
+}
+
+func parseRecord(id string, msg dnsmessage.Message) *Record {
+	var rec *Record
+	name := ""
+	for _, v := range msg.Answers {
+		if !strings.EqualFold(v.Header.Name.String(), instanceName(id)) {
+			continue
+		}
+		switch body := v.Body.(type) {
+		case *dnsmessage.SRVResource:
+			rec = &Record{ID: id, Port: int(body.Port)}
+		case *dnsmessage.TXTResource:
+			for _, txt := range body.TXT {
+				if strings.HasPrefix(txt, "name=") {
+					name = strings.TrimPrefix(txt, "name=")
+				}
+			}
+		}
+	}
+	if rec != nil {
+		rec.Name = name
+	}
+	return rec
+}
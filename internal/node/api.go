@@ -0,0 +1,519 @@
+package node
+
+import (
+	"bufio"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pion/webrtc/v3"
+	"github.com/sirupsen/logrus"
+	"github.com/suutaku/sshx/internal/utils"
+	"github.com/suutaku/sshx/pkg/impl"
+	"github.com/suutaku/sshx/pkg/types"
+)
+
+// version of control API, prefix of all routes
+const apiVersion = "v1"
+
+// used if LocalAPIPort not configured
+const defaultAPIPort = 2225
+
+// protocol name of Upgrade header, the upgraded connection carries raw impl data
+const apiUpgradeProtocol = "sshx"
+
+// header carries pair id of upgraded session
+const apiSessionHeader = "Sshx-Session"
+
+//go:embed api_schema.json
+var apiSchema []byte
+
+// APIInfo describes the daemon
+type APIInfo struct {
+	ID           string   `json:"id"`
+	Name         string   `json:"name"`
+	Version      string   `json:"version"`
+	APIVersion   string   `json:"api_version"`
+	Applications []string `json:"applications"`
+}
+
+// APISession is a connection pair of the daemon
+type APISession struct {
+	ID          string    `json:"id"`
+	Target      string    `json:"target"`
+	Parent      string    `json:"parent,omitempty"`
+	Application string    `json:"application"`
+	Transport   string    `json:"transport"`
+	StartTime   time.Time `json:"start_time"`
+}
+
+// APIConnectRequest opens a session to host, options are fields of the application
+type APIConnectRequest struct {
+	Application string          `json:"application"`
+	Host        string          `json:"host"`
+	Options     json.RawMessage `json:"options,omitempty"`
+}
+
+// APIConfigValue is value of a configure key
+type APIConfigValue struct {
+	Key   string      `json:"key,omitempty"`
+	Value interface{} `json:"value"`
+}
+
+// APIError is body of all failed requests
+type APIError struct {
+	Error string `json:"error"`
+	Code  string `json:"code,omitempty"`
+}
+
+// codes of APIError and HTTP status of failures reported by connection manager
+var (
+	apiErrorCodes = map[int32]string{
+		types.STATUS_FAILED:       "failed",
+		types.STATUS_PEER_OFFLINE: "peer_offline",
+		types.STATUS_ACL_DENIED:   "acl_denied",
+		types.STATUS_IMPL_UNKNOWN: "impl_unknown",
+		types.STATUS_ICE_FAILED:   "ice_failed",
+		types.STATUS_TIMEOUT:      "timeout",
+		types.STATUS_REMOTE_ERROR: "remote_error",
+		types.STATUS_NOT_FOUND:    "not_found",
+	}
+	apiStatus = map[int32]int{
+		types.STATUS_FAILED:       http.StatusBadGateway,
+		types.STATUS_PEER_OFFLINE: http.StatusServiceUnavailable,
+		types.STATUS_ACL_DENIED:   http.StatusForbidden,
+		types.STATUS_IMPL_UNKNOWN: http.StatusNotImplemented,
+		types.STATUS_ICE_FAILED:   http.StatusBadGateway,
+		types.STATUS_TIMEOUT:      http.StatusGatewayTimeout,
+		types.STATUS_REMOTE_ERROR: http.StatusBadGateway,
+		types.STATUS_NOT_FOUND:    http.StatusNotFound,
+	}
+)
+
+// ServeAPI serves JSON control API on loopback, the gob protocol of ServeTCP is kept for CLI
+func (node *Node) ServeAPI() {
+	port := node.confManager.Conf().LocalAPIPort
+	if port <= 0 {
+		port = defaultAPIPort
+	}
+	token, err := node.confManager.APIToken()
+	if err != nil {
+		logrus.Error("control API disabled, no token: ", err)
+		return
+	}
+	node.apiToken = token
+	r := mux.NewRouter()
+	api := r.PathPrefix("/" + apiVersion).Subrouter()
+	api.Handle("/info", node.apiInfo()).Methods(http.MethodGet)
+	api.Handle("/schema", node.apiSchema()).Methods(http.MethodGet)
+	api.Handle("/sessions", node.apiSessions()).Methods(http.MethodGet)
+	api.Handle("/sessions", node.apiConnect()).Methods(http.MethodPost)
+	api.Handle("/sessions/{id}", node.apiDisconnect()).Methods(http.MethodDelete)
+	api.Handle("/sessions/{id}/attach", node.apiAttach()).Methods(http.MethodPost)
+	api.Handle("/config", node.apiConfig()).Methods(http.MethodGet)
+	api.Handle("/config/{key}", node.apiGetConfig()).Methods(http.MethodGet)
+	api.Handle("/config/{key}", node.apiSetConfig()).Methods(http.MethodPut)
+
+	addr := fmt.Sprintf("127.0.0.1:%d", port)
+	logrus.Info("control API listening on ", addr)
+	err = http.ListenAndServe(addr, node.apiGuard(r))
+	if err != nil {
+		logrus.Error(err)
+	}
+}
+
+// apiGuard only lets local programs in: browsers (Origin header or a foreign Host)
+// are refused and every request needs the bearer token
+func (node *Node) apiGuard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Origin") != "" || !isLoopbackHost(r.Host) {
+			apiFail(w, http.StatusForbidden, fmt.Errorf("request from browser refused"))
+			return
+		}
+		token := node.confManager.Conf().APIToken
+		if token == "" {
+			token = node.apiToken
+		}
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			logrus.Warn("unauthorized API request from ", r.RemoteAddr)
+			apiFail(w, http.StatusUnauthorized, fmt.Errorf("invalid token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isLoopbackHost(hostport string) bool {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = hostport
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	return ip != nil && ip.IsLoopback()
+}
+
+func apiReply(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logrus.Error("json encode failed:", err)
+	}
+}
+
+// apiFail replies err, with its code if it's a *types.Error
+func apiFail(w http.ResponseWriter, code int, err error) {
+	res := APIError{Error: err.Error()}
+	var e *types.Error
+	if errors.As(err, &e) {
+		res.Code = apiErrorCodes[e.Code]
+		if res.Code == "" {
+			res.Code = apiErrorCodes[types.STATUS_FAILED]
+		}
+	}
+	if code == 0 {
+		code = http.StatusBadGateway
+	}
+	apiReply(w, code, res)
+}
+
+// apiDecode reads JSON body into v
+func apiDecode(r *http.Request, v interface{}) error {
+	mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mt != "application/json" {
+		return fmt.Errorf("content type must be application/json")
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// appName returns lower case name of impl code, e.g. ssh
+func appName(code int32) string {
+	return strings.ToLower(strings.TrimPrefix(impl.GetImplName(code), "*"))
+}
+
+// appCode returns impl code of name given by appName
+func appCode(name string) (int32, error) {
+	for _, v := range impl.RegisteredCodes() {
+		if appName(v) == strings.ToLower(name) {
+			return v, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown application %s", name)
+}
+
+func (node *Node) apiInfo() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apps := make([]string, 0)
+		for _, v := range impl.RegisteredCodes() {
+			apps = append(apps, appName(v))
+		}
+		apiReply(w, http.StatusOK, APIInfo{
+			ID:           node.confManager.Conf().ID,
+			Name:         node.confManager.Conf().DeviceName(),
+			Version:      types.Version,
+			APIVersion:   apiVersion,
+			Applications: apps,
+		})
+	})
+}
+
+func (node *Node) apiSchema() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/schema+json")
+		w.Write(apiSchema)
+	})
+}
+
+func (node *Node) sessions() []APISession {
+	ret := make([]APISession, 0)
+	for _, v := range node.connMgr.Stat() {
+		ret = append(ret, APISession{
+			ID:          v.PairId,
+			Target:      v.TargetId,
+			Parent:      v.ParentPairId,
+			Application: appName(v.ImplType),
+			Transport:   v.Transport,
+			StartTime:   v.StartTime,
+		})
+	}
+	return ret
+}
+
+func (node *Node) apiSessions() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiReply(w, http.StatusOK, node.sessions())
+	})
+}
+
+// apiConnect opens a session, the HTTP connection is upgraded to carry its data
+func (node *Node) apiConnect() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req APIConnectRequest
+		if err := apiDecode(r, &req); err != nil {
+			apiFail(w, http.StatusBadRequest, err)
+			return
+		}
+		if req.Host == "" {
+			apiFail(w, http.StatusBadRequest, fmt.Errorf("host is required"))
+			return
+		}
+		code, err := appCode(req.Application)
+		if err != nil {
+			apiFail(w, http.StatusBadRequest, err)
+			return
+		}
+		if !isUpgrade(r) {
+			apiFail(w, http.StatusUpgradeRequired, fmt.Errorf("session data needs Upgrade: %s", apiUpgradeProtocol))
+			return
+		}
+		imp := impl.GetImpl(code)
+		if len(req.Options) > 0 {
+			if err := json.Unmarshal(req.Options, imp); err != nil {
+				apiFail(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+		imp.SetHostId(node.confManager.Conf().ResolveHost(req.Host))
+		imp.NeedConnect()
+		sender := impl.NewSenderWithConf(node.confManager, imp, types.OPTION_TYPE_UP)
+		poolId := types.NewPoolId(time.Now().UnixNano(), imp.Code())
+		// setup is aborted if client goes away before upgraded
+		node.upgrade(w, sender, func(sock net.Conn) error {
+			return node.connMgr.CreateConnection(r.Context(), sender, sock, *poolId)
+		})
+	})
+}
+
+// apiAttach joins a running session, the HTTP connection is upgraded to carry its data
+func (node *Node) apiAttach() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		var session *APISession
+		for _, v := range node.sessions() {
+			if v.ID == id {
+				session = &v
+				break
+			}
+		}
+		if session == nil {
+			apiFail(w, http.StatusNotFound, fmt.Errorf("session %s not found", id))
+			return
+		}
+		if !isUpgrade(r) {
+			apiFail(w, http.StatusUpgradeRequired, fmt.Errorf("session data needs Upgrade: %s", apiUpgradeProtocol))
+			return
+		}
+		code, _ := appCode(session.Application)
+		imp := impl.GetImpl(code)
+		imp.SetHostId(session.Target)
+		sender := impl.NewSenderWithConf(node.confManager, imp, types.OPTION_TYPE_ATTACH)
+		sender.PairId = []byte(id)
+		node.upgrade(w, sender, func(sock net.Conn) error {
+			return node.connMgr.AttachConnection(sender, sock)
+		})
+	})
+}
+
+func (node *Node) apiDisconnect() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := node.connMgr.RemoveConnection(mux.Vars(r)["id"])
+		if err != nil {
+			apiFail(w, http.StatusNotFound, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func isUpgrade(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), apiUpgradeProtocol) &&
+		strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade")
+}
+
+// bufferedConn reads data buffered by http server before the rest of connection
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (bc *bufferedConn) Read(b []byte) (int, error) {
+	return bc.reader.Read(b)
+}
+
+// upgrade runs request of sender on one end of a pipe the way ServeTCP does,
+// then switches protocols and pipes the HTTP connection to it
+func (node *Node) upgrade(w http.ResponseWriter, sender *impl.Sender, request func(net.Conn) error) {
+	local, remote := net.Pipe()
+	// failures are answered on the pipe too, which blocks until read
+	go func() {
+		if err := request(remote); err != nil {
+			logrus.Debug(err)
+		}
+	}()
+	// read byte by byte, impl data follows the response
+	err := gob.NewDecoder(utils.ByteReader{Reader: local}).Decode(sender)
+	if err != nil {
+		local.Close()
+		apiFail(w, http.StatusBadGateway, err)
+		return
+	}
+	if sender.Status != types.STATUS_OK {
+		local.Close()
+		apiFail(w, apiStatus[sender.Status], &types.Error{Code: sender.Status, Message: sender.Message})
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		local.Close()
+		apiFail(w, http.StatusInternalServerError, fmt.Errorf("connection cannot be upgraded"))
+		return
+	}
+	sock, rw, err := hj.Hijack()
+	if err != nil {
+		local.Close()
+		logrus.Error(err)
+		return
+	}
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: %s\r\n%s: %s\r\n\r\n",
+		apiUpgradeProtocol, apiSessionHeader, string(sender.PairId))
+	err = rw.Flush()
+	if err != nil {
+		local.Close()
+		sock.Close()
+		logrus.Error(err)
+		return
+	}
+	logrus.Debug("API session ", string(sender.PairId), " upgraded")
+	var upgraded net.Conn = &bufferedConn{Conn: sock, reader: rw.Reader}
+	go utils.Pipe(&upgraded, &local)
+}
+
+// apiConfig returns whole configure, secrets are masked
+func (node *Node) apiConfig() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res := *node.confManager.Conf()
+		res.SignalingToken = maskSecret(res.SignalingToken)
+		res.APIToken = maskSecret(res.APIToken)
+		res.VNCConf.Password = maskSecret(res.VNCConf.Password)
+		// servers are shared with configure of daemon
+		res.RTCConf.ICEServers = append([]webrtc.ICEServer{}, res.RTCConf.ICEServers...)
+		for i, v := range res.RTCConf.ICEServers {
+			if v.Credential != nil {
+				res.RTCConf.ICEServers[i].Credential = maskSecret(fmt.Sprint(v.Credential))
+			}
+		}
+		apiReply(w, http.StatusOK, res)
+	})
+}
+
+func maskSecret(s string) string {
+	if s == "" {
+		return s
+	}
+	return "******"
+}
+
+func (node *Node) apiGetConfig() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := mux.Vars(r)["key"]
+		value, ok := node.confManager.Get(key)
+		if !ok {
+			apiFail(w, http.StatusNotFound, fmt.Errorf("configure key %s not found", key))
+			return
+		}
+		value = maskSecrets(key, value)
+		apiReply(w, http.StatusOK, APIConfigValue{Key: key, Value: value})
+	})
+}
+
+// apiSetConfig sets a configure key, value keeps its JSON type
+func (node *Node) apiSetConfig() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := mux.Vars(r)["key"]
+		var req APIConfigValue
+		if err := apiDecode(r, &req); err != nil {
+			apiFail(w, http.StatusBadRequest, err)
+			return
+		}
+		if !isWritableKey(key) {
+			apiFail(w, http.StatusForbidden, fmt.Errorf("configure key %s can only be set by sshx conf", key))
+			return
+		}
+		if err := node.confManager.SetValue(key, req.Value); err != nil {
+			apiFail(w, http.StatusInternalServerError, err)
+			return
+		}
+		value, _ := node.confManager.Get(key)
+		apiReply(w, http.StatusOK, APIConfigValue{Key: key, Value: maskSecrets(key, value)})
+	})
+}
+
+// keys settable by API, keys of access control and local ports need access to configure file
+var apiWritableKeys = map[string]bool{
+	"name":                true,
+	"hosts":               true,
+	"signalingserveraddr": true,
+	"signalingtoken":      true,
+	"rtcconf":             true,
+	"vncconf":             true,
+}
+
+// isWritableKey checks top level key, e.g. rtcconf of rtcconf.iceservers
+func isWritableKey(key string) bool {
+	return apiWritableKeys[strings.SplitN(strings.ToLower(key), ".", 2)[0]]
+}
+
+// isSecretKey checks last segment of key, e.g. password of vncconf.password
+func isSecretKey(key string) bool {
+	sps := strings.Split(strings.ToLower(key), ".")
+	return secretNames[sps[len(sps)-1]]
+}
+
+// names of secret fields at any level of configure
+var secretNames = map[string]bool{
+	"signalingtoken": true,
+	"apitoken":       true,
+	"password":       true,
+	"credential":     true,
+}
+
+// maskSecrets returns value of key with secrets masked, including nested ones
+// of maps and lists, e.g. credential of rtcconf.iceservers
+func maskSecrets(key string, value interface{}) interface{} {
+	if isSecretKey(key) {
+		if value == nil {
+			return nil
+		}
+		return maskSecret(fmt.Sprint(value))
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		// a copy, value belongs to viper
+		ret := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			ret[k] = maskSecrets(k, item)
+		}
+		return ret
+	case []interface{}:
+		ret := make([]interface{}, len(v))
+		for i, item := range v {
+			ret[i] = maskSecrets("", item)
+		}
+		return ret
+	}
+	return value
+}
@@ -0,0 +1,118 @@
+package node
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/suutaku/sshx/pkg/conf"
+)
+
+func TestAPIGuard(t *testing.T) {
+	cases := []struct {
+		name      string
+		confToken string
+		fileToken string
+		host      string
+		origin    string
+		auth      string
+		status    int
+	}{
+		{"token of file", "", "file", "127.0.0.1:2225", "", "Bearer file", http.StatusOK},
+		{"localhost", "", "file", "localhost:2225", "", "Bearer file", http.StatusOK},
+		{"ipv6 loopback", "", "file", "[::1]:2225", "", "Bearer file", http.StatusOK},
+		{"token of configure", "conf", "file", "127.0.0.1:2225", "", "Bearer conf", http.StatusOK},
+		{"token of file overridden", "conf", "file", "127.0.0.1:2225", "", "Bearer file", http.StatusUnauthorized},
+		{"no token", "", "file", "127.0.0.1:2225", "", "", http.StatusUnauthorized},
+		{"wrong token", "", "file", "127.0.0.1:2225", "", "Bearer wrong", http.StatusUnauthorized},
+		{"prefix of token", "", "file", "127.0.0.1:2225", "", "Bearer fil", http.StatusUnauthorized},
+		{"no token loaded", "", "", "127.0.0.1:2225", "", "Bearer ", http.StatusUnauthorized},
+		{"from browser", "", "file", "127.0.0.1:2225", "http://evil.example.com", "Bearer file", http.StatusForbidden},
+		{"rebound host", "", "file", "evil.example.com:2225", "", "Bearer file", http.StatusForbidden},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// NewConfManager clears entries of known_hosts in $HOME
+			t.Setenv("HOME", t.TempDir())
+			cm := conf.NewConfManager(t.TempDir())
+			cm.Set("APIToken", c.confToken)
+			node := &Node{confManager: cm, apiToken: c.fileToken}
+			handler := node.apiGuard(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/v1/info", nil)
+			req.Host = c.host
+			if c.origin != "" {
+				req.Header.Set("Origin", c.origin)
+			}
+			if c.auth != "" {
+				req.Header.Set("Authorization", c.auth)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != c.status {
+				t.Errorf("status = %d, want %d", rec.Code, c.status)
+			}
+		})
+	}
+}
+
+func TestIsWritableKey(t *testing.T) {
+	cases := []struct {
+		key      string
+		writable bool
+	}{
+		{"Name", true},
+		{"hosts", true},
+		{"RTCConf.ICEServers", true},
+		{"SignalingToken", true},
+		{"APIToken", false},
+		{"TrustedPeers", false},
+		{"ACL.Rules", false},
+		{"AuthorizedKeys", false},
+		{"BuiltinSSH", false},
+		{"ControlUIDs", false},
+		{"name2", false},
+	}
+	for _, c := range cases {
+		t.Run(c.key, func(t *testing.T) {
+			if got := isWritableKey(c.key); got != c.writable {
+				t.Errorf("isWritableKey(%s) = %v, want %v", c.key, got, c.writable)
+			}
+		})
+	}
+}
+
+func TestMaskSecrets(t *testing.T) {
+	cases := []struct {
+		key   string
+		value interface{}
+		want  string
+	}{
+		{"SignalingToken", "token", "******"},
+		{"vncconf.password", "vnc", "******"},
+		{"VNCConf.Password", "", ""},
+		{"name", "office", "office"},
+		{"vncconf", map[string]interface{}{"password": "vnc", "port": 5900}, "map[password:****** port:5900]"},
+		{"rtcconf.iceservers", []interface{}{
+			map[string]interface{}{"urls": []interface{}{"turn:a"}, "username": "u", "credential": "c"},
+		}, "[map[credential:****** urls:[turn:a] username:u]]"},
+		{"rtcconf", map[string]interface{}{"iceservers": []interface{}{
+			map[string]interface{}{"credential": "c"},
+		}}, "map[iceservers:[map[credential:******]]]"},
+	}
+	for _, c := range cases {
+		t.Run(c.key, func(t *testing.T) {
+			if got := fmt.Sprint(maskSecrets(c.key, c.value)); got != c.want {
+				t.Errorf("maskSecrets(%s) = %s, want %s", c.key, got, c.want)
+			}
+		})
+	}
+	// values of viper are not modified
+	value := map[string]interface{}{"password": "vnc"}
+	maskSecrets("vncconf", value)
+	if value["password"] != "vnc" {
+		t.Error("masked value of configure")
+	}
+}
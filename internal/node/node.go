@@ -1,6 +1,7 @@
 package node
 
 import (
+	"github.com/sirupsen/logrus"
 	"github.com/suutaku/sshx/internal/conn"
 	"github.com/suutaku/sshx/pkg/conf"
 )
@@ -9,13 +10,16 @@ type Node struct {
 	confManager *conf.ConfManager
 	running     bool
 	connMgr     *conn.ConnectionManager
+	// token of control API stored under home, APIToken of configure overrides it
+	apiToken string
 }
 
 func NewNode(home string) *Node {
 	cm := conf.NewConfManager(home)
 	enabledService := []conn.ConnectionService{
-		conn.NewDirectService(cm.Conf.ID),
-		conn.NewWebRTCService(cm.Conf.ID, cm.Conf.SignalingServerAddr, cm.Conf.RTCConf),
+		conn.NewDirectService(cm),
+		conn.NewQUICService(cm),
+		conn.NewWebRTCService(cm),
 	}
 	return &Node{
 		confManager: cm,
@@ -26,6 +30,22 @@ func NewNode(home string) *Node {
 func (node *Node) Start() {
 	node.running = true
 	go node.connMgr.Start()
+	go node.ServeAPI()
+	if !node.confManager.ServeControlTCP() {
+		err := node.ServeUnix()
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		return
+	}
+	// anyone on this device can use the TCP port
+	logrus.Warn("serve CLI requests on 127.0.0.1:", node.confManager.Conf().LocalTCPPort, " without access control")
+	go func() {
+		err := node.ServeUnix()
+		if err != nil {
+			logrus.Error(err)
+		}
+	}()
 	node.ServeTCP()
 }
 
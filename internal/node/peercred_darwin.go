@@ -0,0 +1,30 @@
+package node
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+func getPeerCred(conn *net.UnixConn) (*peerCred, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var cred *unix.Xucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptXucred(int(fd), unix.SOL_LOCAL, unix.LOCAL_PEERCRED)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if credErr != nil {
+		return nil, credErr
+	}
+	ret := &peerCred{Uid: cred.Uid}
+	if cred.Ngroups > 0 {
+		ret.Gid = cred.Groups[0]
+	}
+	return ret, nil
+}
@@ -0,0 +1,12 @@
+//go:build !linux && !darwin
+
+package node
+
+import (
+	"fmt"
+	"net"
+)
+
+func getPeerCred(conn *net.UnixConn) (*peerCred, error) {
+	return nil, fmt.Errorf("peer credential not supported")
+}
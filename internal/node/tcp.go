@@ -1,6 +1,7 @@
 package node
 
 import (
+	"context"
 	"encoding/gob"
 	"fmt"
 	"net"
@@ -12,7 +13,7 @@ import (
 )
 
 func (node *Node) ServeTCP() {
-	listenner, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", node.confManager.Conf.LocalTCPPort))
+	listenner, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", node.confManager.Conf().LocalTCPPort))
 	if err != nil {
 		logrus.Error(err)
 		panic(err)
@@ -24,48 +25,58 @@ func (node *Node) ServeTCP() {
 			logrus.Error(err)
 			continue
 		}
-		tmp := impl.Sender{}
-		err = gob.NewDecoder(sock).Decode(&tmp)
+		// owner unknown
+		node.handle(sock, nil)
+	}
+}
+
+// handle serves a request of CLI, cred is requester on unix socket, nil on TCP
+func (node *Node) handle(sock net.Conn, cred *peerCred) {
+	tmp := impl.Sender{}
+	err := gob.NewDecoder(sock).Decode(&tmp)
+	if err != nil {
+		logrus.Debug("read not ok", err)
+		sock.Close()
+		return
+	}
+	switch tmp.GetOptionCode() {
+	case types.OPTION_TYPE_UP:
+		logrus.Debug("up option")
+		impl := tmp.GetImpl()
+		if impl == nil {
+			logrus.Error("unkwon implementation")
+			node.connMgr.Refuse(&tmp, sock, types.NewError(types.STATUS_IMPL_UNKNOWN, "unknown impl code %d", tmp.GetAppCode()))
+			return
+		}
+		node.connectAgent(&tmp, cred)
+		poolId := types.NewPoolId(time.Now().UnixNano(), impl.Code())
+		// requester is answered by connection manager
+		err := node.connMgr.CreateConnection(context.Background(), &tmp, sock, *poolId)
 		if err != nil {
-			logrus.Debug("read not ok", err)
-			sock.Close()
-			continue
+			logrus.Error(err)
 		}
-		switch tmp.GetOptionCode() {
-		case types.OPTION_TYPE_UP:
-			logrus.Debug("up option")
-			impl := tmp.GetImpl()
-			if impl == nil {
-				logrus.Error("unkwon implementation")
-				continue
-			}
-			poolId := types.NewPoolId(time.Now().UnixNano(), impl.Code())
-			err := node.connMgr.CreateConnection(&tmp, sock, *poolId)
-			if err != nil {
-				sock.Close()
-				logrus.Error(err)
-			}
 
-		case types.OPTION_TYPE_DOWN:
-			logrus.Debug("down option ", string(tmp.PairId))
-			err := node.connMgr.DestroyConnection(&tmp, sock)
-			if err != nil {
-				logrus.Error(err)
-			}
+	case types.OPTION_TYPE_DOWN:
+		logrus.Debug("down option ", string(tmp.PairId))
+		err := node.connMgr.DestroyConnection(&tmp, sock)
+		if err != nil {
+			logrus.Error(err)
+		}
 
-		case types.OPTION_TYPE_STAT:
-			logrus.Debug("stat option")
-			err := node.connMgr.Status(tmp, sock)
-			if err != nil {
-				sock.Close()
-				logrus.Error(err)
-			}
-		case types.OPTION_TYPE_ATTACH:
-			logrus.Debug("attach option")
-			err := node.connMgr.AttachConnection(&tmp, sock)
-			if err != nil {
-				logrus.Error(err)
-			}
+	case types.OPTION_TYPE_STAT:
+		logrus.Debug("stat option")
+		err := node.connMgr.Status(tmp, sock)
+		if err != nil {
+			sock.Close()
+			logrus.Error(err)
+		}
+	case types.OPTION_TYPE_ATTACH:
+		logrus.Debug("attach option")
+		err := node.connMgr.AttachConnection(&tmp, sock)
+		if err != nil {
+			logrus.Error(err)
 		}
+	default:
+		node.connMgr.Refuse(&tmp, sock, fmt.Errorf("unknown option %d", tmp.GetOptionCode()))
 	}
 }
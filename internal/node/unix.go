@@ -0,0 +1,145 @@
+package node
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/suutaku/sshx/pkg/impl"
+)
+
+// peer credential of a unix socket connection
+type peerCred struct {
+	Uid uint32
+	Gid uint32
+}
+
+// ServeUnix serves CLI requests on unix socket under SSHX_HOME, only
+// root, user of daemon and users of ControlUIDs or ControlGIDs are served
+func (node *Node) ServeUnix() error {
+	path := node.confManager.ControlSocket()
+	if path == "" {
+		logrus.Debug("unix control socket not supported on this platform")
+		return nil
+	}
+	listenner, err := listenUnix(path)
+	if err != nil {
+		return err
+	}
+	defer listenner.Close()
+	// permission is checked by peer credential
+	err = os.Chmod(path, 0666)
+	if err != nil {
+		return err
+	}
+	for node.running {
+		sock, err := listenner.Accept()
+		if err != nil {
+			logrus.Error(err)
+			continue
+		}
+		cred, err := getPeerCred(sock.(*net.UnixConn))
+		if err != nil {
+			logrus.Error(err)
+			sock.Close()
+			continue
+		}
+		if !node.isAllowedPeer(cred) {
+			logrus.Warn("refuse control request of uid ", cred.Uid)
+			sock.Close()
+			continue
+		}
+		node.handle(sock, cred)
+	}
+	return nil
+}
+
+// listenUnix listens on path, a socket left by a daemon not stopped cleanly is replaced,
+// fails if another daemon still serves it
+func listenUnix(path string) (net.Listener, error) {
+	info, err := os.Lstat(path)
+	if err == nil && info.Mode()&os.ModeSocket != 0 {
+		conn, err := net.DialTimeout("unix", path, time.Second)
+		if err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("another daemon is serving %s", path)
+		}
+		os.Remove(path)
+	}
+	return net.Listen("unix", path)
+}
+
+// connectAgent connects ssh-agent of requester for applications running in daemon,
+// the agent must run as requester since daemon can open agents of other users
+func (node *Node) connectAgent(sender *impl.Sender, cred *peerCred) {
+	path := sender.AgentSocket()
+	if path == "" {
+		return
+	}
+	if cred == nil {
+		logrus.Warn("ignore ssh-agent of requester, owner unknown")
+		return
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		logrus.Debug("ssh-agent not available: ", err)
+		return
+	}
+	owner, err := getPeerCred(conn.(*net.UnixConn))
+	if err != nil || owner.Uid != cred.Uid {
+		logrus.Warn("refuse ssh-agent ", path, ", not run by uid ", cred.Uid)
+		conn.Close()
+		return
+	}
+	sender.SetAgentConn(conn)
+}
+
+func (node *Node) isAllowedPeer(cred *peerCred) bool {
+	if cred.Uid == 0 || cred.Uid == uint32(os.Getuid()) {
+		return true
+	}
+	for _, v := range node.confManager.Conf().ControlUIDs {
+		if v == cred.Uid {
+			return true
+		}
+	}
+	if len(node.confManager.Conf().ControlGIDs) == 0 {
+		return false
+	}
+	gids, err := groupsOf(cred)
+	if err != nil {
+		logrus.Error(err)
+	}
+	for _, v := range node.confManager.Conf().ControlGIDs {
+		for _, gid := range gids {
+			if v == gid {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// groupsOf returns primary and supplementary groups of peer
+func groupsOf(cred *peerCred) ([]uint32, error) {
+	ret := []uint32{cred.Gid}
+	u, err := user.LookupId(strconv.FormatUint(uint64(cred.Uid), 10))
+	if err != nil {
+		return ret, err
+	}
+	ids, err := u.GroupIds()
+	if err != nil {
+		return ret, fmt.Errorf("cannot get groups of %s: %v", u.Username, err)
+	}
+	for _, v := range ids {
+		gid, err := strconv.ParseUint(v, 10, 32)
+		if err == nil {
+			ret = append(ret, uint32(gid))
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,51 @@
+package node
+
+import (
+	"net"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestListenUnix(t *testing.T) {
+	sock := path.Join(t.TempDir(), "sshx.sock")
+	live, err := listenUnix(sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// socket of a running daemon is kept
+	if l, err := listenUnix(sock); err == nil {
+		l.Close()
+		t.Fatal("listened on socket of running daemon")
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		t.Fatalf("socket of running daemon removed: %v", err)
+	}
+	conn.Close()
+
+	// socket left by a stopped daemon is replaced
+	live.(*net.UnixListener).SetUnlinkOnClose(false)
+	live.Close()
+	if _, err := os.Lstat(sock); err != nil {
+		t.Fatal(err)
+	}
+	l, err := listenUnix(sock)
+	if err != nil {
+		t.Fatalf("stale socket not replaced: %v", err)
+	}
+	l.Close()
+
+	// other files are not removed
+	file := path.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if l, err := listenUnix(file); err == nil {
+		l.Close()
+		t.Error("listened on path of a file")
+	}
+	if _, err := os.Stat(file); err != nil {
+		t.Error("file removed")
+	}
+}
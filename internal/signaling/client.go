@@ -0,0 +1,181 @@
+package signaling
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"github.com/suutaku/sshx/pkg/conf"
+	"github.com/suutaku/sshx/pkg/types"
+)
+
+// ErrNoStream returned by DialStream if server only supports pull api
+var ErrNoStream = fmt.Errorf("signaling server has no stream api")
+
+// timeout of http requests to signaling server, none of them waits for data
+const requestTimeout = 10 * time.Second
+
+// Client of signaling server apis
+type Client struct {
+	confManager *conf.ConfManager
+	httpClient  *http.Client
+	wsDialer    *websocket.Dialer
+}
+
+func NewClient(cm *conf.ConfManager) *Client {
+	tlsConf, err := cm.Conf().SignalingTLSConfig()
+	if err != nil {
+		logrus.Error("cannot load signaling CA: ", err)
+	}
+	wsDialer := *websocket.DefaultDialer
+	wsDialer.TLSClientConfig = tlsConf
+	return &Client{
+		confManager: cm,
+		httpClient: &http.Client{
+			Timeout: requestTimeout,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: tlsConf,
+			},
+		},
+		wsDialer: &wsDialer,
+	}
+}
+
+// authorization header of signaling server
+func (c *Client) header() http.Header {
+	header := http.Header{}
+	if token := c.confManager.Conf().SignalingToken; token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+	return header
+}
+
+func (c *Client) do(method, api, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.confManager.Conf().SignalingServerAddr+api, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = c.header()
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: %s", method, api, resp.Status)
+	}
+	return resp, nil
+}
+
+// Push sends info to mailbox of info.Target
+func (c *Client) Push(info types.SignalingInfo) error {
+	buf := bytes.NewBuffer(nil)
+	if err := gob.NewEncoder(buf).Encode(info); err != nil {
+		return err
+	}
+	resp, err := c.do(http.MethodPost, path.Join("/", "push", info.Target), "application/binary", buf)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Pull takes one info from mailbox of id, returns error if mailbox was empty
+func (c *Client) Pull(id string) (types.SignalingInfo, error) {
+	var info types.SignalingInfo
+	resp, err := c.do(http.MethodGet, path.Join("/", "pull", id), "", nil)
+	if err != nil {
+		return info, err
+	}
+	defer resp.Body.Close()
+	err = gob.NewDecoder(resp.Body).Decode(&info)
+	return info, err
+}
+
+func (c *Client) streamURL(id string) (string, error) {
+	u, err := url.Parse(c.confManager.Conf().SignalingServerAddr)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	default:
+		u.Scheme = "ws"
+	}
+	u.Path = path.Join(u.Path, "ws", id)
+	return u.String(), nil
+}
+
+// DialStream opens a websocket which receives infos of mailbox id
+func (c *Client) DialStream(id string) (*websocket.Conn, error) {
+	addr, err := c.streamURL(id)
+	if err != nil {
+		return nil, err
+	}
+	conn, resp, err := c.wsDialer.Dial(addr, c.header())
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil, ErrNoStream
+		}
+		if resp != nil && resp.StatusCode == http.StatusUnauthorized {
+			logrus.Error("unauthorized by signaling server, check SignalingToken")
+		}
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Register announces node to signaling server, should be called periodically
+func (c *Client) Register(info types.PeerInfo) error {
+	bs, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(http.MethodPost, "/register", "application/json", bytes.NewBuffer(bs))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Peers lists online nodes
+func (c *Client) Peers() ([]types.PeerInfo, error) {
+	resp, err := c.do(http.MethodGet, "/peers", "", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var peers []types.PeerInfo
+	err = json.NewDecoder(resp.Body).Decode(&peers)
+	return peers, err
+}
+
+// TURN fetches a TURN credential for node id, fails if server issues none
+func (c *Client) TURN(id string) (*types.TURNCredential, error) {
+	resp, err := c.do(http.MethodGet, "/turn?id="+url.QueryEscape(id), "", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var cred types.TURNCredential
+	err = json.NewDecoder(resp.Body).Decode(&cred)
+	if err != nil {
+		return nil, err
+	}
+	return &cred, nil
+}
@@ -0,0 +1,151 @@
+package sshd
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/user"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+const hostKeyFileName = ".sshx_host_key"
+
+// host key is generated once by concurrent connections
+var hostKeyLock sync.Mutex
+
+// Server is an embedded ssh server, sessions run as the user of daemon
+type Server struct {
+	config         *ssh.ServerConfig
+	authorizedKeys string
+	user           *user.User
+}
+
+// NewServer returns server with host key under homePath, clients are authenticated
+// by authorizedKeys, ~/.ssh/authorized_keys if empty
+func NewServer(homePath, authorizedKeys string) (*Server, error) {
+	u, err := user.Current()
+	if err != nil {
+		return nil, err
+	}
+	if authorizedKeys == "" {
+		authorizedKeys = path.Join(u.HomeDir, ".ssh", "authorized_keys")
+	}
+	signer, err := loadHostKey(path.Join(homePath, hostKeyFileName))
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		authorizedKeys: authorizedKeys,
+		user:           u,
+	}
+	s.config = &ssh.ServerConfig{
+		PublicKeyCallback: s.checkKey,
+	}
+	s.config.AddHostKey(signer)
+	return s, nil
+}
+
+// Pipe serves a new ssh connection and returns client side of it
+func (s *Server) Pipe() net.Conn {
+	client, server := net.Pipe()
+	go s.ServeConn(server)
+	return client
+}
+
+// ServeConn serves ssh connection on conn until closed
+func (s *Server) ServeConn(conn net.Conn) {
+	defer conn.Close()
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		logrus.Warn("ssh handshake failed: ", err)
+		return
+	}
+	defer sconn.Close()
+	logrus.Info("ssh login of ", sconn.User(), " by key ", sconn.Permissions.Extensions["pubkey-fp"])
+	go ssh.DiscardRequests(reqs)
+	for newChan := range chans {
+		if newChan.ChannelType() != "session" {
+			newChan.Reject(ssh.UnknownChannelType, "only session channels are supported")
+			continue
+		}
+		ch, chReqs, err := newChan.Accept()
+		if err != nil {
+			logrus.Error(err)
+			continue
+		}
+		go newSession(s, sconn, ch).serve(chReqs)
+	}
+	logrus.Debug("ssh connection of ", sconn.User(), " closed")
+}
+
+// checkKey accepts keys listed in authorized keys, read on every login to apply changes.
+// Options like command= or from= are not supported, keys restricted by them are refused
+func (s *Server) checkKey(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	if meta.User() != s.user.Username {
+		return nil, fmt.Errorf("only %s can log in", s.user.Username)
+	}
+	keys, err := ioutil.ReadFile(s.authorizedKeys)
+	if err != nil {
+		return nil, err
+	}
+	for len(keys) > 0 {
+		authorized, _, options, rest, err := ssh.ParseAuthorizedKey(keys)
+		if err != nil {
+			break
+		}
+		if bytes.Equal(authorized.Marshal(), key.Marshal()) {
+			if len(options) > 0 {
+				logrus.Warn("refuse key ", ssh.FingerprintSHA256(key), ", options ", strings.Join(options, ","), " of ", s.authorizedKeys, " not supported")
+				return nil, fmt.Errorf("key %s of %s has unsupported options", ssh.FingerprintSHA256(key), meta.User())
+			}
+			return &ssh.Permissions{
+				Extensions: map[string]string{"pubkey-fp": ssh.FingerprintSHA256(key)},
+			}, nil
+		}
+		keys = rest
+	}
+	return nil, fmt.Errorf("key %s of %s not authorized", ssh.FingerprintSHA256(key), meta.User())
+}
+
+// loadHostKey reads host key from keyPath, a new one will be generated if not exist
+func loadHostKey(keyPath string) (ssh.Signer, error) {
+	hostKeyLock.Lock()
+	defer hostKeyLock.Unlock()
+	pemBytes, err := ioutil.ReadFile(keyPath)
+	if os.IsNotExist(err) {
+		pemBytes, err = generateHostKey(keyPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ssh.ParsePrivateKey(pemBytes)
+}
+
+func generateHostKey(keyPath string) ([]byte, error) {
+	logrus.Info("generate ssh host key at ", keyPath)
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	err = ioutil.WriteFile(keyPath, pemBytes, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return pemBytes, nil
+}
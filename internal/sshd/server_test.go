@@ -0,0 +1,72 @@
+package sshd
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"os/user"
+	"path"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+type testConnMetadata struct {
+	user string
+}
+
+func (m testConnMetadata) User() string          { return m.user }
+func (m testConnMetadata) SessionID() []byte     { return nil }
+func (m testConnMetadata) ClientVersion() []byte { return nil }
+func (m testConnMetadata) ServerVersion() []byte { return nil }
+func (m testConnMetadata) RemoteAddr() net.Addr  { return &net.TCPAddr{} }
+func (m testConnMetadata) LocalAddr() net.Addr   { return &net.TCPAddr{} }
+
+func newTestKey(t *testing.T) (ssh.PublicKey, string) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, string(ssh.MarshalAuthorizedKey(key))
+}
+
+func TestCheckKey(t *testing.T) {
+	key, line := newTestKey(t)
+	other, otherLine := newTestKey(t)
+	cases := []struct {
+		name       string
+		user       string
+		authorized string
+		key        ssh.PublicKey
+		ok         bool
+	}{
+		{"listed", "alice", line, key, true},
+		{"listed after other", "alice", "# comment\n" + otherLine + line, key, true},
+		{"not listed", "alice", otherLine, key, false},
+		{"other user", "bob", line, key, false},
+		{"forced command", "alice", `command="/bin/true" ` + line, key, false},
+		{"source restricted", "alice", `from="10.0.0.1" ` + line, key, false},
+		{"restricted", "alice", "restrict " + line, key, false},
+		{"no pty", "alice", "no-pty " + line, key, false},
+		{"options of other key", "alice", "restrict " + otherLine + line, key, true},
+		{"other key restricted", "alice", "no-pty " + otherLine, other, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			file := path.Join(t.TempDir(), "authorized_keys")
+			if err := ioutil.WriteFile(file, []byte(c.authorized), 0600); err != nil {
+				t.Fatal(err)
+			}
+			s := &Server{authorizedKeys: file, user: &user.User{Username: "alice"}}
+			_, err := s.checkKey(testConnMetadata{c.user}, c.key)
+			if (err == nil) != c.ok {
+				t.Errorf("checkKey() error = %v, want ok %v", err, c.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,323 @@
+package sshd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/creack/pty"
+	"github.com/pkg/sftp"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// payloads of channel requests, see RFC 4254
+type ptyRequest struct {
+	Term    string
+	Columns uint32
+	Rows    uint32
+	Width   uint32
+	Height  uint32
+	Modes   string
+}
+
+type windowChange struct {
+	Columns uint32
+	Rows    uint32
+	Width   uint32
+	Height  uint32
+}
+
+type envRequest struct {
+	Name  string
+	Value string
+}
+
+type execRequest struct {
+	Command string
+}
+
+type exitStatus struct {
+	Status uint32
+}
+
+// session runs one shell, command or subsystem on a session channel
+type session struct {
+	server  *Server
+	conn    ssh.Conn
+	ch      ssh.Channel
+	env     []string
+	pty     *ptyRequest
+	tty     *os.File
+	cmd     *exec.Cmd
+	started bool
+	// socket of forwarded agent, removed with its directory on close
+	agentListener net.Listener
+	agentDir      string
+	lock          sync.Mutex
+}
+
+func newSession(server *Server, conn ssh.Conn, ch ssh.Channel) *session {
+	return &session{
+		server: server,
+		conn:   conn,
+		ch:     ch,
+	}
+}
+
+// serve handles requests until client closed the channel
+func (sess *session) serve(reqs <-chan *ssh.Request) {
+	for req := range reqs {
+		err := sess.handle(req)
+		if err != nil {
+			logrus.Warn("ssh ", req.Type, " request failed: ", err)
+		}
+		if req.WantReply {
+			req.Reply(err == nil, nil)
+		}
+	}
+	sess.close()
+}
+
+func (sess *session) handle(req *ssh.Request) error {
+	switch req.Type {
+	case "pty-req":
+		var p ptyRequest
+		if err := ssh.Unmarshal(req.Payload, &p); err != nil {
+			return err
+		}
+		sess.lock.Lock()
+		sess.pty = &p
+		sess.lock.Unlock()
+		return nil
+	case "window-change":
+		var w windowChange
+		if err := ssh.Unmarshal(req.Payload, &w); err != nil {
+			return err
+		}
+		return sess.resize(w)
+	case "env":
+		var e envRequest
+		if err := ssh.Unmarshal(req.Payload, &e); err != nil {
+			return err
+		}
+		if !acceptEnv(e.Name) {
+			// e.g. LD_PRELOAD or PATH would change programs run by user
+			return fmt.Errorf("environment variable %s not accepted", e.Name)
+		}
+		sess.lock.Lock()
+		sess.env = append(sess.env, e.Name+"="+e.Value)
+		sess.lock.Unlock()
+		return nil
+	case "auth-agent-req@openssh.com":
+		return sess.forwardAgent()
+	case "shell":
+		return sess.start("")
+	case "exec":
+		var e execRequest
+		if err := ssh.Unmarshal(req.Payload, &e); err != nil {
+			return err
+		}
+		return sess.start(e.Command)
+	case "subsystem":
+		var e execRequest
+		if err := ssh.Unmarshal(req.Payload, &e); err != nil {
+			return err
+		}
+		if e.Command != "sftp" {
+			return fmt.Errorf("unknown subsystem %s", e.Command)
+		}
+		return sess.startSFTP()
+	}
+	return fmt.Errorf("unsupported request")
+}
+
+// acceptEnv reports whether client may set variable name, like AcceptEnv of OpenSSH
+func acceptEnv(name string) bool {
+	return name == "LANG" || name == "TERM" || strings.HasPrefix(name, "LC_")
+}
+
+func (sess *session) resize(w windowChange) error {
+	sess.lock.Lock()
+	defer sess.lock.Unlock()
+	if sess.pty == nil {
+		return fmt.Errorf("no pty")
+	}
+	sess.pty.Columns, sess.pty.Rows = w.Columns, w.Rows
+	if sess.tty == nil {
+		return nil
+	}
+	return pty.Setsize(sess.tty, &pty.Winsize{Cols: uint16(w.Columns), Rows: uint16(w.Rows)})
+}
+
+// forwardAgent listens a socket for programs of session, connections are
+// forwarded to agent of client
+func (sess *session) forwardAgent() error {
+	sess.lock.Lock()
+	defer sess.lock.Unlock()
+	if sess.agentListener != nil {
+		return nil
+	}
+	// only owner can access the directory
+	dir, err := ioutil.TempDir("", "sshx-agent")
+	if err != nil {
+		return err
+	}
+	sock := path.Join(dir, "agent.sock")
+	listener, err := net.Listen("unix", sock)
+	if err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	sess.agentListener = listener
+	sess.agentDir = dir
+	sess.env = append(sess.env, "SSH_AUTH_SOCK="+sock)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go sess.serveAgent(conn)
+		}
+	}()
+	return nil
+}
+
+func (sess *session) serveAgent(conn net.Conn) {
+	defer conn.Close()
+	ch, reqs, err := sess.conn.OpenChannel("auth-agent@openssh.com", nil)
+	if err != nil {
+		logrus.Debug("cannot open agent channel: ", err)
+		return
+	}
+	defer ch.Close()
+	go ssh.DiscardRequests(reqs)
+	go func() {
+		io.Copy(ch, conn)
+		ch.CloseWrite()
+	}()
+	io.Copy(conn, ch)
+}
+
+// begin marks session started, only one program runs on a channel
+func (sess *session) begin() error {
+	sess.lock.Lock()
+	defer sess.lock.Unlock()
+	if sess.started {
+		return fmt.Errorf("session already started")
+	}
+	sess.started = true
+	return nil
+}
+
+// start runs command by shell of user, a login shell if command is empty
+func (sess *session) start(command string) error {
+	if err := sess.begin(); err != nil {
+		return err
+	}
+	cmd := shellCommand(sess.server.user, command)
+	cmd.Dir = sess.server.user.HomeDir
+	cmd.Env = append(userEnv(sess.server.user, cmd.Path), sess.env...)
+
+	sess.lock.Lock()
+	defer sess.lock.Unlock()
+	sess.cmd = cmd
+	output := make(chan struct{})
+	if sess.pty != nil {
+		cmd.Env = append(cmd.Env, "TERM="+sess.pty.Term)
+		tty, err := pty.StartWithSize(cmd, &pty.Winsize{Cols: uint16(sess.pty.Columns), Rows: uint16(sess.pty.Rows)})
+		if err != nil {
+			return err
+		}
+		sess.tty = tty
+		go io.Copy(tty, sess.ch)
+		go func() {
+			io.Copy(sess.ch, tty)
+			close(output)
+		}()
+	} else {
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return err
+		}
+		// not waited by cmd, reading channel blocks until client closed it
+		go func() {
+			io.Copy(stdin, sess.ch)
+			stdin.Close()
+		}()
+		cmd.Stdout = sess.ch
+		cmd.Stderr = sess.ch.Stderr()
+		if err := cmd.Start(); err != nil {
+			return err
+		}
+		close(output)
+	}
+	go func() {
+		err := cmd.Wait()
+		// rest of output is read from pty once program exited
+		<-output
+		sess.exit(err)
+	}()
+	return nil
+}
+
+func (sess *session) startSFTP() error {
+	if err := sess.begin(); err != nil {
+		return err
+	}
+	server, err := sftp.NewServer(sess.ch)
+	if err != nil {
+		return err
+	}
+	go func() {
+		err := server.Serve()
+		if err == io.EOF {
+			err = nil
+		}
+		sess.exit(err)
+	}()
+	return nil
+}
+
+// exit tells client exit status of program and closes channel
+func (sess *session) exit(err error) {
+	var status uint32
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() >= 0 {
+		status = uint32(exitErr.ExitCode())
+	} else if err != nil {
+		logrus.Debug("ssh session ended: ", err)
+		status = 255
+	}
+	_, sendErr := sess.ch.SendRequest("exit-status", false, ssh.Marshal(exitStatus{status}))
+	if sendErr != nil {
+		logrus.Debug(sendErr)
+	}
+	sess.ch.Close()
+}
+
+// close stops program of a channel closed by client
+func (sess *session) close() {
+	sess.lock.Lock()
+	defer sess.lock.Unlock()
+	if sess.tty != nil {
+		// program gets SIGHUP
+		sess.tty.Close()
+	}
+	if sess.cmd != nil && sess.cmd.Process != nil && sess.cmd.ProcessState == nil {
+		sess.cmd.Process.Kill()
+	}
+	if sess.agentListener != nil {
+		sess.agentListener.Close()
+		os.RemoveAll(sess.agentDir)
+	}
+	sess.ch.Close()
+}
@@ -0,0 +1,50 @@
+//go:build !windows
+
+package sshd
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"os/user"
+	"path"
+	"strings"
+)
+
+// loginShell returns shell of u from /etc/passwd, /bin/sh if not found
+func loginShell(u *user.User) string {
+	f, err := os.Open("/etc/passwd")
+	if err != nil {
+		return "/bin/sh"
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) == 7 && fields[0] == u.Username && fields[6] != "" {
+			return fields[6]
+		}
+	}
+	return "/bin/sh"
+}
+
+func shellCommand(u *user.User, command string) *exec.Cmd {
+	shell := loginShell(u)
+	if command == "" {
+		cmd := exec.Command(shell)
+		// leading dash makes a login shell
+		cmd.Args = []string{"-" + path.Base(shell)}
+		return cmd
+	}
+	return exec.Command(shell, "-c", command)
+}
+
+func userEnv(u *user.User, shell string) []string {
+	return []string{
+		"HOME=" + u.HomeDir,
+		"USER=" + u.Username,
+		"LOGNAME=" + u.Username,
+		"SHELL=" + shell,
+		"PATH=" + os.Getenv("PATH"),
+	}
+}
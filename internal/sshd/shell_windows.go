@@ -0,0 +1,22 @@
+package sshd
+
+import (
+	"os"
+	"os/exec"
+	"os/user"
+)
+
+func shellCommand(u *user.User, command string) *exec.Cmd {
+	shell := os.Getenv("COMSPEC")
+	if shell == "" {
+		shell = "cmd.exe"
+	}
+	if command == "" {
+		return exec.Command(shell)
+	}
+	return exec.Command(shell, "/C", command)
+}
+
+func userEnv(u *user.User, shell string) []string {
+	return append(os.Environ(), "USERPROFILE="+u.HomeDir)
+}
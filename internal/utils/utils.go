@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
@@ -67,6 +68,31 @@ func Pipe(con1 *net.Conn, con2 *net.Conn) error {
 	return <-errCh
 }
 
+// CloseOnDone closes c if ctx is done before stop is called
+func CloseOnDone(ctx context.Context, c io.Closer) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+// ByteReader reads one byte at a time, so a decoder on top of it
+// never consumes data beyond its own message
+type ByteReader struct {
+	io.Reader
+}
+
+func (br ByteReader) ReadByte() (byte, error) {
+	var b [1]byte
+	_, err := io.ReadFull(br.Reader, b[:])
+	return b[0], err
+}
+
 func ToNetConn(wsconn *websocket.Conn) *net.Conn {
 	return &[]net.Conn{
 		wsconn,
@@ -79,11 +105,8 @@ func HashString(input string) string {
 	return hex.EncodeToString(h.Sum(nil))
 }
 
-func DebugOn() bool {
-	str := os.Getenv("SSHX_DEBUG")
-	if str == "" {
-		return false
-	}
+// IsTrue parses boolean values of environment variables
+func IsTrue(str string) bool {
 	lowStr := strings.ToLower(str)
 	if lowStr == "1" || lowStr == "true" || lowStr == "yes" {
 		return true
@@ -91,6 +114,10 @@ func DebugOn() bool {
 	return false
 }
 
+func DebugOn() bool {
+	return IsTrue(os.Getenv("SSHX_DEBUG"))
+}
+
 func GetSSHXHome() string {
 	home := os.Getenv("SSHX_HOME")
 	if home == "" {
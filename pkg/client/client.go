@@ -0,0 +1,305 @@
+package client
+
+import (
+	"context"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/suutaku/sshx/internal/utils"
+	"github.com/suutaku/sshx/pkg/conf"
+	"github.com/suutaku/sshx/pkg/impl"
+	"github.com/suutaku/sshx/pkg/types"
+)
+
+// wait for daemon to close a session
+const downTimeout = 5 * time.Second
+
+// Client requests a running sshx daemon the way sshx command does
+type Client struct {
+	cm *conf.ConfManager
+}
+
+// NewClient returns client of daemon whose configure is under home, SSHX_HOME if empty,
+// the configure is read once and left untouched
+func NewClient(home string) (*Client, error) {
+	cm, err := conf.LoadConfManager(home)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		cm: cm,
+	}, nil
+}
+
+// ResolveHost returns node ID of an alias, host itself if it's not an alias
+func (c *Client) ResolveHost(host string) string {
+	return c.cm.Conf().ResolveHost(host)
+}
+
+func (c *Client) send(ctx context.Context, imp impl.Impl, optCode int32, pairId string, detach bool) (*impl.Sender, net.Conn, error) {
+	sender := impl.NewSenderWithConf(c.cm, imp, optCode)
+	if sender == nil {
+		return nil, nil, fmt.Errorf("cannot create sender for %s", impl.GetImplName(imp.Code()))
+	}
+	if pairId != "" {
+		sender.PairId = []byte(pairId)
+	}
+	sender.Detach = detach
+	conn, err := sender.SendContext(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sender, conn, nil
+}
+
+// Connect opens imp on its host, imp must be prepared.
+// The session is closed and removed from daemon once ctx is done.
+func (c *Client) Connect(ctx context.Context, imp impl.Impl) (*Session, error) {
+	sender, conn, err := c.send(ctx, imp, types.OPTION_TYPE_UP, "", false)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect %s to %s: %w", impl.GetImplName(imp.Code()), imp.HostId(), err)
+	}
+	return newSession(ctx, c, conn, string(sender.PairId), imp, false), nil
+}
+
+// DialSSH returns a stream to sshd of host, host is node ID, alias or address
+func (c *Client) DialSSH(ctx context.Context, host string) (*Session, error) {
+	return c.Connect(ctx, &impl.SSH{BaseImpl: *impl.NewBaseImpl(c.ResolveHost(host))})
+}
+
+// Attach joins a running session of pairId, e.g. a messager
+func (c *Client) Attach(ctx context.Context, pairId string) (*Session, error) {
+	stat, err := c.find(ctx, pairId)
+	if err != nil {
+		return nil, err
+	}
+	imp := impl.GetImpl(stat.ImplType)
+	imp.SetHostId(stat.TargetId)
+	sender, conn, err := c.send(ctx, imp, types.OPTION_TYPE_ATTACH, pairId, false)
+	if err != nil {
+		return nil, fmt.Errorf("cannot attach %s: %w", pairId, err)
+	}
+	return newSession(ctx, c, conn, pairId, sender.GetImpl(), true), nil
+}
+
+// Stat returns status of all sessions of daemon
+func (c *Client) Stat(ctx context.Context) ([]types.Status, error) {
+	imp := impl.NewSTAT()
+	imp.SetHostId(c.cm.Conf().ID)
+	_, conn, err := c.send(ctx, imp, types.OPTION_TYPE_STAT, "", false)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	stop := utils.CloseOnDone(ctx, conn)
+	defer stop()
+	res := []types.Status{}
+	err = gob.NewEncoder(conn).Encode(res)
+	if err != nil {
+		return nil, err
+	}
+	err = gob.NewDecoder(conn).Decode(&res)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (c *Client) find(ctx context.Context, pairId string) (*types.Status, error) {
+	stats, err := c.Stat(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range stats {
+		if v.PairId == pairId {
+			return &v, nil
+		}
+	}
+	return nil, types.NewError(types.STATUS_NOT_FOUND, "no session %s", pairId)
+}
+
+// Disconnect closes session of pairId
+func (c *Client) Disconnect(ctx context.Context, pairId string) error {
+	stat, err := c.find(ctx, pairId)
+	if err != nil {
+		return err
+	}
+	imp := impl.GetImpl(stat.ImplType)
+	imp.SetHostId(stat.TargetId)
+	return c.down(ctx, imp, pairId)
+}
+
+func (c *Client) down(ctx context.Context, imp impl.Impl, pairId string) error {
+	imp.NoNeedConnect()
+	_, conn, err := c.send(ctx, imp, types.OPTION_TYPE_DOWN, pairId, true)
+	if err != nil {
+		return fmt.Errorf("cannot close %s: %w", pairId, err)
+	}
+	conn.Close()
+	return nil
+}
+
+// Upload sends local file to Downloads directory of host
+func (c *Client) Upload(ctx context.Context, host, filePath string) error {
+	transfer := impl.NewTransfer(c.ResolveHost(host), filePath, true, nil)
+	if transfer == nil {
+		return fmt.Errorf("file path is required")
+	}
+	session, err := c.Connect(ctx, transfer)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	transfer.SetConn(session)
+	return transfer.DoUpload(nil)
+}
+
+// Download writes remote file of host to w, to local Downloads directory if w is nil
+func (c *Client) Download(ctx context.Context, host, remotePath string, w io.Writer) error {
+	transfer := impl.NewTransfer(c.ResolveHost(host), remotePath, false, nil)
+	if transfer == nil {
+		return fmt.Errorf("remote path is required")
+	}
+	session, err := c.Connect(ctx, transfer)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	transfer.SetConn(session)
+	return transfer.DoDownload(w)
+}
+
+// OpenProxy forwards 127.0.0.1:port to sshd of host, a free port is chosen if port is 0
+func (c *Client) OpenProxy(ctx context.Context, host string, port int32) (*Proxy, error) {
+	host = c.ResolveHost(host)
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return nil, err
+	}
+	// parent session of every forwarded connection
+	imp := impl.NewProxy(int32(listener.Addr().(*net.TCPAddr).Port), host)
+	imp.SetHostId(host)
+	imp.NoNeedConnect()
+	sender, conn, err := c.send(ctx, imp, types.OPTION_TYPE_UP, "", true)
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("cannot open proxy to %s: %w", host, err)
+	}
+	p := &Proxy{
+		listener: listener,
+		host:     host,
+		client:   c,
+	}
+	p.Session = newSession(ctx, c, conn, string(sender.PairId), imp, false)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-p.stop:
+		}
+		listener.Close()
+	}()
+	go p.serve(ctx)
+	return p, nil
+}
+
+// Session is a connection to an application of remote device
+type Session struct {
+	net.Conn
+	PairId    string
+	client    *Client
+	imp       impl.Impl
+	stop      chan struct{}
+	closeOnce sync.Once
+	// joined by Attach, session is left running on Close
+	attached bool
+}
+
+func newSession(ctx context.Context, c *Client, conn net.Conn, pairId string, imp impl.Impl, attached bool) *Session {
+	s := &Session{
+		Conn:     conn,
+		PairId:   pairId,
+		client:   c,
+		imp:      imp,
+		stop:     make(chan struct{}),
+		attached: attached,
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			logrus.Debug("context of ", pairId, " done")
+			s.Close()
+		case <-s.stop:
+		}
+	}()
+	return s
+}
+
+// Impl returns application of session, updated by daemon on attach
+func (s *Session) Impl() impl.Impl {
+	return s.imp
+}
+
+// Close closes connection, and session on daemon if it was created by Connect
+func (s *Session) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.stop)
+		err = s.Conn.Close()
+		if s.attached {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), downTimeout)
+		defer cancel()
+		// session may be gone with the connection already
+		imp := impl.GetImpl(s.imp.Code())
+		imp.SetHostId(s.imp.HostId())
+		if downErr := s.client.down(ctx, imp, s.PairId); downErr != nil {
+			logrus.Debug(downErr)
+		}
+	})
+	return err
+}
+
+// Proxy forwards local connections to sshd of a remote device,
+// Close stops listening and closes all forwarded connections
+type Proxy struct {
+	*Session
+	listener net.Listener
+	host     string
+	client   *Client
+}
+
+// Addr returns local address of proxy
+func (p *Proxy) Addr() net.Addr {
+	return p.listener.Addr()
+}
+
+func (p *Proxy) serve(ctx context.Context) {
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			logrus.Debug("proxy for ", p.host, " stopped: ", err)
+			return
+		}
+		go func() {
+			imp := &impl.SSH{BaseImpl: *impl.NewBaseImpl(p.host)}
+			imp.SetParentId(p.PairId)
+			session, err := p.client.Connect(ctx, imp)
+			if err != nil {
+				logrus.Error(err)
+				conn.Close()
+				return
+			}
+			var remote net.Conn = session
+			utils.Pipe(&conn, &remote)
+		}()
+	}
+}
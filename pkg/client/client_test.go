@@ -0,0 +1,147 @@
+//go:build linux || darwin
+
+package client
+
+import (
+	"context"
+	"encoding/gob"
+	"net"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/suutaku/sshx/pkg/impl"
+	"github.com/suutaku/sshx/pkg/types"
+)
+
+// fakeDaemon answers requests on control socket under home, reports every request on reqs
+type fakeDaemon struct {
+	listener net.Listener
+	reqs     chan impl.Sender
+}
+
+func newFakeDaemon(t *testing.T, home string) *fakeDaemon {
+	err := os.WriteFile(path.Join(home, ".sshx_config.json"), []byte(`{"ID": "self", "LocalTCPPort": 2224}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("unix", path.Join(home, "sshx.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &fakeDaemon{
+		listener: listener,
+		reqs:     make(chan impl.Sender, 16),
+	}
+	t.Cleanup(func() { listener.Close() })
+	go d.serve()
+	return d
+}
+
+func (d *fakeDaemon) serve() {
+	for {
+		conn, err := d.listener.Accept()
+		if err != nil {
+			return
+		}
+		go d.handle(conn)
+	}
+}
+
+func (d *fakeDaemon) handle(conn net.Conn) {
+	var sender impl.Sender
+	if err := gob.NewDecoder(conn).Decode(&sender); err != nil {
+		conn.Close()
+		return
+	}
+	d.reqs <- sender
+	if sender.GetOptionCode() == types.OPTION_TYPE_UP {
+		sender.PairId = []byte("pair")
+	}
+	sender.Status = types.STATUS_OK
+	if err := gob.NewEncoder(conn).Encode(sender); err != nil {
+		conn.Close()
+		return
+	}
+	switch sender.GetOptionCode() {
+	case types.OPTION_TYPE_STAT:
+		defer conn.Close()
+		res := []types.Status{}
+		if err := gob.NewDecoder(conn).Decode(&res); err != nil {
+			return
+		}
+		res = []types.Status{{TargetId: "peer", ImplType: types.APP_TYPE_SSH, PairId: "pair"}}
+		gob.NewEncoder(conn).Encode(res)
+	case types.OPTION_TYPE_UP:
+		// session stays open until requester leaves
+		buf := make([]byte, 1)
+		conn.Read(buf)
+		conn.Close()
+	default:
+		conn.Close()
+	}
+}
+
+func (d *fakeDaemon) next(t *testing.T) impl.Sender {
+	select {
+	case sender := <-d.reqs:
+		return sender
+	case <-time.After(5 * time.Second):
+		t.Fatal("no request to daemon")
+	}
+	return impl.Sender{}
+}
+
+func TestNewClientNoConfigure(t *testing.T) {
+	home := t.TempDir()
+	if _, err := NewClient(home); err == nil {
+		t.Error("client without configure of daemon")
+	}
+	if files, _ := os.ReadDir(home); len(files) != 0 {
+		t.Errorf("NewClient created %v", files)
+	}
+}
+
+func TestClient(t *testing.T) {
+	home := t.TempDir()
+	daemon := newFakeDaemon(t, home)
+	c, err := NewClient(home)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := c.Stat(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 1 || stats[0].PairId != "pair" {
+		t.Errorf("Stat() = %v", stats)
+	}
+	if req := daemon.next(t); req.GetOptionCode() != types.OPTION_TYPE_STAT {
+		t.Errorf("option %d, want stat", req.GetOptionCode())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	session, err := c.DialSSH(ctx, "peer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.PairId != "pair" {
+		t.Errorf("pair id = %s", session.PairId)
+	}
+	req := daemon.next(t)
+	if req.GetOptionCode() != types.OPTION_TYPE_UP || req.GetAppCode() != types.APP_TYPE_SSH {
+		t.Errorf("option %d of app %d, want up of ssh", req.GetOptionCode(), req.GetAppCode())
+	}
+	if host := req.GetImpl().HostId(); host != "peer" {
+		t.Errorf("host = %s", host)
+	}
+
+	// cancelling context closes session on daemon
+	cancel()
+	req = daemon.next(t)
+	if req.GetOptionCode() != types.OPTION_TYPE_DOWN || string(req.PairId) != "pair" {
+		t.Errorf("option %d of %s, want down of pair", req.GetOptionCode(), req.PairId)
+	}
+}
@@ -0,0 +1,67 @@
+package conf
+
+import "strings"
+
+const (
+	aclAnyPeer     = "*"
+	aclGroupPrefix = "group:"
+)
+
+// ACLRule allows a set of peers to use a set of applications.
+// Peers can be node IDs, "group:<name>" or "*" for any peer.
+// Apps are APP_TYPE_* codes, note that scp and fs are carried by ssh.
+type ACLRule struct {
+	Peers []string
+	Apps  []int32
+}
+
+// ACL of remote requests, every request is allowed if no rule was set
+type ACL struct {
+	Groups map[string][]string
+	Rules  []ACLRule
+}
+
+func (acl *ACL) inGroup(group, peer string) bool {
+	for k, members := range acl.Groups {
+		// viper stores map keys in lower case
+		if !strings.EqualFold(k, group) {
+			continue
+		}
+		for _, v := range members {
+			if v == peer {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (acl *ACL) matchPeer(rule ACLRule, peer string) bool {
+	for _, v := range rule.Peers {
+		if v == aclAnyPeer || v == peer {
+			return true
+		}
+		if strings.HasPrefix(v, aclGroupPrefix) && acl.inGroup(strings.TrimPrefix(v, aclGroupPrefix), peer) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAllowed reports whether peer can request application app on this device
+func (acl *ACL) IsAllowed(peer string, app int32) bool {
+	if len(acl.Rules) == 0 {
+		return true
+	}
+	for _, rule := range acl.Rules {
+		if !acl.matchPeer(rule, peer) {
+			continue
+		}
+		for _, v := range rule.Apps {
+			if v == app {
+				return true
+			}
+		}
+	}
+	return false
+}
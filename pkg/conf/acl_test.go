@@ -0,0 +1,47 @@
+package conf
+
+import (
+	"testing"
+
+	"github.com/suutaku/sshx/pkg/types"
+)
+
+func TestACLIsAllowed(t *testing.T) {
+	groups := map[string][]string{
+		// viper stores map keys in lower case
+		"family": {"phone", "tablet"},
+	}
+	cases := []struct {
+		name  string
+		acl   ACL
+		peer  string
+		app   int32
+		allow bool
+	}{
+		{"no rule", ACL{}, "anyone", types.APP_TYPE_SSH, true},
+		{"no rule with groups", ACL{Groups: groups}, "anyone", types.APP_TYPE_VNC, true},
+		{"peer and app", ACL{Rules: []ACLRule{{Peers: []string{"laptop"}, Apps: []int32{types.APP_TYPE_SSH}}}}, "laptop", types.APP_TYPE_SSH, true},
+		{"other app", ACL{Rules: []ACLRule{{Peers: []string{"laptop"}, Apps: []int32{types.APP_TYPE_SSH}}}}, "laptop", types.APP_TYPE_VNC, false},
+		{"other peer", ACL{Rules: []ACLRule{{Peers: []string{"laptop"}, Apps: []int32{types.APP_TYPE_SSH}}}}, "phone", types.APP_TYPE_SSH, false},
+		{"any peer", ACL{Rules: []ACLRule{{Peers: []string{"*"}, Apps: []int32{types.APP_TYPE_MESSAGER}}}}, "phone", types.APP_TYPE_MESSAGER, true},
+		{"no apps", ACL{Rules: []ACLRule{{Peers: []string{"*"}}}}, "phone", types.APP_TYPE_SSH, false},
+		{"group member", ACL{Groups: groups, Rules: []ACLRule{{Peers: []string{"group:Family"}, Apps: []int32{types.APP_TYPE_VNC}}}}, "tablet", types.APP_TYPE_VNC, true},
+		{"not group member", ACL{Groups: groups, Rules: []ACLRule{{Peers: []string{"group:family"}, Apps: []int32{types.APP_TYPE_VNC}}}}, "laptop", types.APP_TYPE_VNC, false},
+		{"unknown group", ACL{Groups: groups, Rules: []ACLRule{{Peers: []string{"group:work"}, Apps: []int32{types.APP_TYPE_VNC}}}}, "phone", types.APP_TYPE_VNC, false},
+		{"later rule allows", ACL{Groups: groups, Rules: []ACLRule{
+			{Peers: []string{"group:family"}, Apps: []int32{types.APP_TYPE_MESSAGER}},
+			{Peers: []string{"phone"}, Apps: []int32{types.APP_TYPE_SSH}},
+		}}, "phone", types.APP_TYPE_SSH, true},
+		{"no rule allows", ACL{Groups: groups, Rules: []ACLRule{
+			{Peers: []string{"group:family"}, Apps: []int32{types.APP_TYPE_MESSAGER}},
+			{Peers: []string{"laptop"}, Apps: []int32{types.APP_TYPE_SSH}},
+		}}, "phone", types.APP_TYPE_SSH, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.acl.IsAllowed(c.peer, c.app); got != c.allow {
+				t.Errorf("IsAllowed(%s, %d) = %v, want %v", c.peer, c.app, got, c.allow)
+			}
+		})
+	}
+}
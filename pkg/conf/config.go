@@ -2,12 +2,16 @@ package conf
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
@@ -21,26 +25,44 @@ import (
 
 type Configure struct {
 	LocalSSHPort        int32
+	BuiltinSSH          bool   // serve ssh by daemon instead of sshd on LocalSSHPort
+	AuthorizedKeys      string // keys of builtin ssh, ~/.ssh/authorized_keys if empty
 	LocalHTTPPort       int32
 	LocalTCPPort        int32
+	ControlTCP          bool // serve CLI on LocalTCPPort too, without access control
+	LocalAPIPort        int32
+	APIToken            string
+	ControlUIDs         []uint32
+	ControlGIDs         []uint32
+	DirectPort          int32
 	ID                  string
+	Name                string
 	SignalingServerAddr string
+	SignalingToken      string
+	SignalingCAFile     string
 	RTCConf             webrtc.Configuration
 	VNCConf             config.Configure
 	VNCStaticPath       string
 	ETHAddr             string
+	TrustedPeers        []TrustedPeer
+	ACL                 ACL
+	Hosts               []HostEntry
 }
 
 type ConfManager struct {
-	Conf  *Configure
 	Viper *viper.Viper
 	Path  string
+	conf  atomic.Pointer[Configure]
+	// serialises changes of Viper and conf
+	lock sync.Mutex
 }
 
 var defaultConfig = Configure{
 	LocalHTTPPort:       80,
 	LocalSSHPort:        22,
 	LocalTCPPort:        2224,
+	LocalAPIPort:        2225,
+	DirectPort:          8099,
 	ID:                  uuid.New().String(),
 	SignalingServerAddr: "http://140.179.153.231:11095",
 	RTCConf: webrtc.Configuration{
@@ -94,13 +116,21 @@ func NewConfManager(homePath string) *ConfManager {
 	vp.SetConfigName(".sshx_config")
 	vp.SetConfigType("json")
 	vp.AddConfigPath(homePath)
+	cm := &ConfManager{
+		Viper: vp,
+		Path:  homePath,
+	}
 	vp.WatchConfig()
 	vp.OnConfigChange(func(e fsnotify.Event) {
-		err := vp.Unmarshal(&tmp)
+		cm.lock.Lock()
+		defer cm.lock.Unlock()
+		next := new(Configure)
+		err := vp.Unmarshal(next)
 		if err != nil {
 			logrus.Error(err)
 			return
 		}
+		cm.conf.Store(next)
 	})
 	err := vp.ReadInConfig() // Find and read the config file
 	if err != nil {
@@ -129,30 +159,184 @@ func NewConfManager(homePath string) *ConfManager {
 	}
 
 	ClearKnownHosts(fmt.Sprintf("127.0.0.1:%d", tmp.LocalSSHPort))
-	return &ConfManager{
-		Conf:  &tmp,
+	cm.conf.Store(&tmp)
+	return cm
+}
+
+// LoadConfManager reads configure under homePath, SSHX_HOME if empty, for clients of a running
+// daemon. Unlike NewConfManager it creates, watches and changes no file.
+func LoadConfManager(homePath string) (*ConfManager, error) {
+	if homePath == "" {
+		homePath = utils.GetSSHXHome()
+	}
+	vp := viper.New()
+	vp.SetConfigName(".sshx_config")
+	vp.SetConfigType("json")
+	vp.AddConfigPath(homePath)
+	err := vp.ReadInConfig()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read configure under %s: %v", homePath, err)
+	}
+	tmp := new(Configure)
+	err = vp.Unmarshal(tmp)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configure under %s: %v", homePath, err)
+	}
+	cm := &ConfManager{
 		Viper: vp,
 		Path:  homePath,
 	}
+	cm.conf.Store(tmp)
+	return cm, nil
+}
+
+// Conf returns current configure, changes replace it instead of modifying,
+// so it must not be modified by callers
+func (cm *ConfManager) Conf() *Configure {
+	return cm.conf.Load()
+}
+
+// Get returns value of key and whether it was set
+func (cm *ConfManager) Get(key string) (interface{}, bool) {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	if !cm.Viper.IsSet(key) {
+		return nil, false
+	}
+	return cm.Viper.Get(key), true
 }
 
 func (cm *ConfManager) Set(key, value string) {
+	err := cm.SetValue(key, value)
+	if err != nil {
+		logrus.Error(err)
+	}
+}
+
+// SetValue sets key to a value of any type, e.g. a list decoded from JSON
+func (cm *ConfManager) SetValue(key string, value interface{}) error {
 	logrus.Info("key/value", key, value)
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	old := cm.Viper.Get(key)
 	cm.Viper.Set(key, value)
-	err := cm.Viper.Unmarshal(cm.Conf)
+	next := new(Configure)
+	err := cm.Viper.Unmarshal(next)
 	if err != nil {
-		logrus.Error(err)
-		return
+		cm.Viper.Set(key, old)
+		return fmt.Errorf("invalid value of %s: %v", key, err)
+	}
+	cm.conf.Store(next)
+	return cm.Viper.WriteConfig()
+}
+
+// SignalingTLSConfig trusts certificates in SignalingCAFile besides system roots,
+// return nil if SignalingCAFile not set
+func (conf *Configure) SignalingTLSConfig() (*tls.Config, error) {
+	if conf.SignalingCAFile == "" {
+		return nil, nil
 	}
-	err = cm.Viper.WriteConfig()
+	pemBytes, err := ioutil.ReadFile(conf.SignalingCAFile)
 	if err != nil {
-		logrus.Error(err)
-		return
+		return nil, err
 	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pemBytes) {
+		return nil, fmt.Errorf("no certificate found in %s", conf.SignalingCAFile)
+	}
+	return &tls.Config{RootCAs: pool}, nil
+}
+
+// ControlSocket returns path of unix socket the daemon listens for CLI requests,
+// empty if the platform has no socket with peer credential
+func (cm *ConfManager) ControlSocket() string {
+	if !ControlSocketSupported {
+		return ""
+	}
+	return path.Join(cm.Path, "sshx.sock")
+}
+
+// ServeControlTCP tells whether daemon listens for CLI requests on LocalTCPPort
+func (cm *ConfManager) ServeControlTCP() bool {
+	return !ControlSocketSupported || cm.Conf().ControlTCP
+}
+
+// Identity loads the identity key pair stored next to configure file
+func (cm *ConfManager) Identity() (*Identity, error) {
+	return LoadIdentity(cm.Path)
+}
+
+// TrustedKey returns the public key of a trusted peer, empty if not trusted
+func (conf *Configure) TrustedKey(id string) string {
+	for _, v := range conf.TrustedPeers {
+		if v.ID == id {
+			return v.PublicKey
+		}
+	}
+	return ""
+}
+
+// DeviceName returns Name, host name if not set
+func (conf *Configure) DeviceName() string {
+	if conf.Name != "" {
+		return conf.Name
+	}
+	name, _ := os.Hostname()
+	return name
+}
+
+// TrustedPeerId returns id of the trusted peer owning public key, empty if not trusted
+func (conf *Configure) TrustedPeerId(publicKey string) string {
+	for _, v := range conf.TrustedPeers {
+		if v.PublicKey == publicKey {
+			return v.ID
+		}
+	}
+	return ""
+}
+
+func (cm *ConfManager) AddTrustedPeer(id, publicKey string) error {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	peers := make([]TrustedPeer, 0)
+	for _, v := range cm.Conf().TrustedPeers {
+		if v.ID != id {
+			peers = append(peers, v)
+		}
+	}
+	peers = append(peers, TrustedPeer{ID: id, PublicKey: publicKey})
+	return cm.setTrustedPeers(peers)
+}
+
+func (cm *ConfManager) RemoveTrustedPeer(id string) error {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	peers := make([]TrustedPeer, 0)
+	for _, v := range cm.Conf().TrustedPeers {
+		if v.ID != id {
+			peers = append(peers, v)
+		}
+	}
+	if len(peers) == len(cm.Conf().TrustedPeers) {
+		return fmt.Errorf("peer %s was not trusted", id)
+	}
+	return cm.setTrustedPeers(peers)
+}
+
+// setTrustedPeers is called with cm.lock held
+func (cm *ConfManager) setTrustedPeers(peers []TrustedPeer) error {
+	cm.Viper.Set("TrustedPeers", peers)
+	next := *cm.Conf()
+	next.TrustedPeers = peers
+	cm.conf.Store(&next)
+	return cm.Viper.WriteConfig()
 }
 
 func (cm *ConfManager) Show() {
-	bs, _ := json.MarshalIndent(cm.Conf, "", "  ")
+	bs, _ := json.MarshalIndent(cm.Conf(), "", "  ")
 	logrus.Info("read configure file at: ", cm.Path+"/.sshx_config.json")
 	logrus.Info(string(bs))
 }
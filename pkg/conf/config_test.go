@@ -0,0 +1,54 @@
+package conf
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestSetValueConcurrent(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	cm := NewConfManager(t.TempDir())
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(3)
+		go func(i int) {
+			defer wg.Done()
+			if err := cm.SetValue("Name", fmt.Sprintf("node%d", i)); err != nil {
+				t.Error(err)
+			}
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			if err := cm.AddHost(HostEntry{Alias: fmt.Sprintf("h%d", i), ID: "id"}); err != nil {
+				t.Error(err)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			cm.Get("Name")
+			_ = cm.Conf().Name
+		}()
+	}
+	wg.Wait()
+	if n := len(cm.Conf().Hosts); n != 20 {
+		t.Errorf("%d hosts, want 20", n)
+	}
+}
+
+func TestSetValueInvalid(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	cm := NewConfManager(t.TempDir())
+	if err := cm.SetValue("Name", "office"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.SetValue("Hosts", "not a list"); err == nil {
+		t.Error("invalid Hosts accepted")
+	}
+	if got := cm.Conf().Name; got != "office" {
+		t.Errorf("Name = %q, want office", got)
+	}
+	if err := cm.SetValue("Name", "home"); err != nil {
+		t.Errorf("configure broken by invalid value: %v", err)
+	}
+}
@@ -0,0 +1,6 @@
+//go:build linux || darwin
+
+package conf
+
+// ControlSocketSupported is true where daemon can check peer credential of unix socket
+const ControlSocketSupported = true
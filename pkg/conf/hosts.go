@@ -0,0 +1,71 @@
+package conf
+
+import "fmt"
+
+// HostEntry is an address book record, maps an alias to a node ID with
+// default login user and identity file
+type HostEntry struct {
+	Alias    string
+	ID       string
+	User     string
+	Identity string
+}
+
+// LookupHost returns address book entry of alias, nil if alias not found
+func (conf *Configure) LookupHost(alias string) *HostEntry {
+	for _, v := range conf.Hosts {
+		if v.Alias == alias {
+			entry := v
+			return &entry
+		}
+	}
+	return nil
+}
+
+// ResolveHost returns node ID of alias, or host itself if it's not an alias
+func (conf *Configure) ResolveHost(host string) string {
+	if entry := conf.LookupHost(host); entry != nil {
+		return entry.ID
+	}
+	return host
+}
+
+func (cm *ConfManager) AddHost(entry HostEntry) error {
+	if entry.Alias == "" || entry.ID == "" {
+		return fmt.Errorf("alias and id are required")
+	}
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	hosts := make([]HostEntry, 0)
+	for _, v := range cm.Conf().Hosts {
+		if v.Alias != entry.Alias {
+			hosts = append(hosts, v)
+		}
+	}
+	hosts = append(hosts, entry)
+	return cm.setHosts(hosts)
+}
+
+func (cm *ConfManager) RemoveHost(alias string) error {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	hosts := make([]HostEntry, 0)
+	for _, v := range cm.Conf().Hosts {
+		if v.Alias != alias {
+			hosts = append(hosts, v)
+		}
+	}
+	if len(hosts) == len(cm.Conf().Hosts) {
+		return fmt.Errorf("host %s not found", alias)
+	}
+	return cm.setHosts(hosts)
+}
+
+// setHosts is called with cm.lock held
+func (cm *ConfManager) setHosts(hosts []HostEntry) error {
+	cm.Viper.Set("Hosts", hosts)
+	next := *cm.Conf()
+	next.Hosts = hosts
+	cm.conf.Store(&next)
+	return cm.Viper.WriteConfig()
+}
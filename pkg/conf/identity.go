@@ -0,0 +1,127 @@
+package conf
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const identityFileName = ".sshx_identity"
+
+// Identity is the Ed25519 key pair of a node, used to sign signaling messages
+type Identity struct {
+	privateKey ed25519.PrivateKey
+}
+
+// TrustedPeer binds a remote node ID to its identity public key
+type TrustedPeer struct {
+	ID        string
+	PublicKey string
+}
+
+// LoadIdentity reads the node identity from home path, a new one will be
+// generated if not exist
+func LoadIdentity(homePath string) (*Identity, error) {
+	keyPath := path.Join(homePath, identityFileName)
+	pemBytes, err := ioutil.ReadFile(keyPath)
+	if os.IsNotExist(err) {
+		return generateIdentity(keyPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("no identity key found in %s", keyPath)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("identity key in %s is not an ed25519 key", keyPath)
+	}
+	return &Identity{privateKey: privateKey}, nil
+}
+
+func generateIdentity(keyPath string) (*Identity, error) {
+	logrus.Info("generate node identity at ", keyPath)
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	err = ioutil.WriteFile(keyPath, pemBytes, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{privateKey: privateKey}, nil
+}
+
+// PublicKey returns base64 encoded public key which can be shared with peers
+func (id *Identity) PublicKey() string {
+	return base64.StdEncoding.EncodeToString(id.privateKey.Public().(ed25519.PublicKey))
+}
+
+func (id *Identity) Sign(msg []byte) []byte {
+	return ed25519.Sign(id.privateKey, msg)
+}
+
+// Certificate returns a self-signed certificate of the identity key, nodes
+// trust each other by the key in certificate rather than a CA
+func (id *Identity) Certificate(nodeId string) (tls.Certificate, error) {
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: nodeId},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(10, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, id.privateKey.Public(), id.privateKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: id.privateKey}, nil
+}
+
+// ParsePublicKey decodes a base64 encoded public key
+func ParsePublicKey(publicKey string) (ed25519.PublicKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("bad public key size %d", len(raw))
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+// VerifySignature checks sig of msg with a base64 encoded public key
+func VerifySignature(publicKey string, msg, sig []byte) error {
+	key, err := ParsePublicKey(publicKey)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(key, msg, sig) {
+		return fmt.Errorf("signature mismatch")
+	}
+	return nil
+}
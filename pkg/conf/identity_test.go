@@ -0,0 +1,60 @@
+package conf
+
+import (
+	"testing"
+)
+
+func TestVerifySignature(t *testing.T) {
+	home := t.TempDir()
+	id, err := LoadIdentity(home)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := LoadIdentity(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := []byte(`{"Source":"a","Target":"b"}`)
+	sig := id.Sign(msg)
+	tampered := append([]byte{}, sig...)
+	tampered[0] ^= 0xff
+
+	cases := []struct {
+		name      string
+		publicKey string
+		msg       []byte
+		sig       []byte
+		ok        bool
+	}{
+		{"signed", id.PublicKey(), msg, sig, true},
+		{"tampered message", id.PublicKey(), []byte(`{"Source":"a","Target":"c"}`), sig, false},
+		{"tampered signature", id.PublicKey(), msg, tampered, false},
+		{"key of other node", other.PublicKey(), msg, sig, false},
+		{"no signature", id.PublicKey(), msg, nil, false},
+		{"bad key", "not base64", msg, sig, false},
+		{"short key", "AAAA", msg, sig, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := VerifySignature(c.publicKey, c.msg, c.sig)
+			if (err == nil) != c.ok {
+				t.Errorf("VerifySignature() error = %v, want ok %v", err, c.ok)
+			}
+		})
+	}
+}
+
+func TestLoadIdentity(t *testing.T) {
+	home := t.TempDir()
+	id, err := LoadIdentity(home)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadIdentity(home)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.PublicKey() != id.PublicKey() {
+		t.Errorf("identity changed after reload")
+	}
+}
@@ -0,0 +1,42 @@
+package conf
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const apiTokenFileName = ".sshx_api_token"
+
+// APIToken returns bearer token of control API, APIToken of configure if set,
+// otherwise the one stored under home path which is generated on first use
+func (cm *ConfManager) APIToken() (string, error) {
+	if cm.Conf().APIToken != "" {
+		return cm.Conf().APIToken, nil
+	}
+	tokenPath := path.Join(cm.Path, apiTokenFileName)
+	b, err := ioutil.ReadFile(tokenPath)
+	if err == nil {
+		return strings.TrimSpace(string(b)), nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+	logrus.Info("generate API token at ", tokenPath)
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(raw)
+	// only user of daemon can read it
+	err = ioutil.WriteFile(tokenPath, []byte(token+"\n"), 0600)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
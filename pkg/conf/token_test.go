@@ -0,0 +1,38 @@
+package conf
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+func TestAPIToken(t *testing.T) {
+	// NewConfManager clears entries of known_hosts in $HOME
+	t.Setenv("HOME", t.TempDir())
+	cm := NewConfManager(t.TempDir())
+	token, err := cm.APIToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(token) != 64 {
+		t.Errorf("generated token %q is too short", token)
+	}
+	info, err := os.Stat(path.Join(cm.Path, apiTokenFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("token file mode = %v, want 0600", info.Mode().Perm())
+	}
+	again, err := cm.APIToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != token {
+		t.Errorf("token changed from %q to %q", token, again)
+	}
+	cm.Set("APIToken", "configured")
+	if got, _ := cm.APIToken(); got != "configured" {
+		t.Errorf("APIToken() = %q, want token of configure", got)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"io"
 	"net"
 	"reflect"
+	"sort"
 	"time"
 )
 
@@ -39,9 +40,17 @@ type Impl interface {
 	SetParentId(string)
 	Attach(net.Conn) error
 	NoNeedConnect()
+	NeedConnect()
 	IsNeedConnect() bool
 }
 
+// agentUser is an application using ssh-agent of requester inside daemon,
+// the agent is connected by daemon rather than by the socket path of request
+type agentUser interface {
+	agentSocket() string
+	setAgentConn(net.Conn)
+}
+
 var registeddApp = []Impl{
 	&SSH{},
 	&Proxy{},
@@ -66,6 +75,16 @@ func GetImpl(code int32) Impl {
 	return nil
 }
 
+// RegisteredCodes returns codes of all supported impls
+func RegisteredCodes() []int32 {
+	ret := make([]int32, 0, len(registeddApp))
+	for _, v := range registeddApp {
+		ret = append(ret, v.Code())
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i] < ret[j] })
+	return ret
+}
+
 func GetImplName(code int32) string {
 	if t := reflect.TypeOf(GetImpl(code)); t.Kind() == reflect.Ptr {
 		return "*" + t.Elem().Name()
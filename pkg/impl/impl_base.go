@@ -32,6 +32,10 @@ func (base *BaseImpl) NoNeedConnect() {
 	base.ConnectNow = false
 }
 
+func (base *BaseImpl) NeedConnect() {
+	base.ConnectNow = true
+}
+
 func (base *BaseImpl) Init() {}
 
 func (base *BaseImpl) Conn() net.Conn {
@@ -145,12 +145,21 @@ func (m *Messager) OpenChatConsole(conn io.ReadWriteCloser) {
 		return
 	}
 	defer term.Restore(0, oldState)
+	width, height, err := term.GetSize(0)
+	if err != nil {
+		return
+	}
 	m.UIOpened = true
 	screen := struct {
 		io.Reader
 		io.Writer
 	}{os.Stdin, os.Stdout}
 	term := term.NewTerminal(screen, "")
+	term.SetSize(width, height)
+	// keeps line editing right after resize
+	defer watchWindowSize(0, func(width, height int) {
+		term.SetSize(width, height)
+	})()
 	term.SetPrompt(string(term.Escape.Red) + "> " + string(term.Escape.Reset))
 
 	rePrefix := string(term.Escape.Cyan) + m.HId + ":" + string(term.Escape.Reset)
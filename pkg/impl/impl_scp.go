@@ -2,13 +2,13 @@ package impl
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"strings"
 
 	"github.com/povsister/scp"
 	"github.com/sirupsen/logrus"
 	"github.com/suutaku/sshx/pkg/types"
-	"golang.org/x/crypto/ssh"
 )
 
 type SCP struct {
@@ -18,11 +18,14 @@ type SCP struct {
 	RemotePath    string
 	Identiry      string
 	TargetAddress string
+	AgentSocket   string // ssh-agent of the user, copy runs on daemon
+	agentConn     net.Conn
 }
 
 func NewSCP(src, dest, ident string) *SCP {
 	ret := &SCP{
-		Identiry: ident,
+		Identiry:    ident,
+		AgentSocket: os.Getenv("SSH_AUTH_SOCK"),
 	}
 	err := ret.ParsePaths(src, dest)
 	if err != nil {
@@ -40,8 +43,23 @@ func (s *SCP) Code() int32 {
 	return types.APP_TYPE_SCP
 }
 
+func (s *SCP) agentSocket() string {
+	return s.AgentSocket
+}
+
+func (s *SCP) setAgentConn(conn net.Conn) {
+	s.agentConn = conn
+}
+
 func (s *SCP) Dial() error {
 	ssht := NewSSH(s.TargetAddress, false, s.Identiry, false)
+	// agent of daemon or socket path of request may belong to other users
+	ssht.AgentSocket = ""
+	ssht.agentConn = s.agentConn
+	if s.agentConn != nil {
+		defer s.agentConn.Close()
+	}
+	ssht.noTerminal = true
 	err := ssht.Preper()
 	if err != nil {
 		logrus.Error(err)
@@ -56,16 +74,11 @@ func (s *SCP) Dial() error {
 	}
 
 	logrus.Debug("create scp conn from dal.conn")
-	ssht.config.Auth = append(ssht.config.Auth, ssh.RetryableAuthMethod(ssh.PasswordCallback(ssht.passwordCallback), NumberOfPrompts))
-	c, chans, reqs, err := ssh.NewClientConn(conn, "", &ssht.config)
+	client, err := ssht.newClient(conn)
 	if err != nil {
 		return err
 	}
 	logrus.Debug("conn ok")
-	client := ssh.NewClient(c, chans, reqs)
-	if client == nil {
-		return fmt.Errorf("cannot create ssh client")
-	}
 	scpClient, err := scp.NewClientFromExistingSSH(client, &scp.ClientOption{})
 	if err != nil {
 		return nil
@@ -124,6 +137,14 @@ func (s *SCP) ParsePaths(src, dest string) error {
 	} else {
 		return fmt.Errorf("bad param: src host %s, dest host %s", srcHost, destHost)
 	}
+	userName, host, identity := resolveAddress(s.TargetAddress)
+	s.TargetAddress = host
+	if userName != "" {
+		s.TargetAddress = userName + "@" + host
+	}
+	if s.Identiry == "" {
+		s.Identiry = identity
+	}
 	return nil
 }
 
@@ -17,9 +17,11 @@ import (
 
 	"github.com/povsister/scp"
 	"github.com/sirupsen/logrus"
+	"github.com/suutaku/sshx/internal/sshd"
 	"github.com/suutaku/sshx/pkg/conf"
 	"github.com/suutaku/sshx/pkg/types"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 	"golang.org/x/crypto/ssh/knownhosts"
 	"golang.org/x/crypto/ssh/terminal"
 )
@@ -28,21 +30,35 @@ const NumberOfPrompts = 3
 
 var keyErr *knownhosts.KeyError
 
+// secrets are asked on terminal of CLI, applications dialed by daemon have none
+var errNoTerminal = errors.New("interactive auth not supported for cp/mount")
+
+// private keys tried in order if no identity is given, like ssh does
+var defaultIdentities = []string{"id_ed25519", "id_ecdsa", "id_rsa"}
+
 type SSH struct {
 	BaseImpl
-	X11       bool
-	Address   string
-	CopyIdOpt bool
-	Identify  string
-	config    ssh.ClientConfig
+	X11          bool
+	Address      string
+	CopyIdOpt    bool
+	Identify     string
+	Command      string // run by Exec instead of a shell
+	Tty          bool   // request pty for Command
+	AgentSocket  string // ssh-agent of the user, SSH_AUTH_SOCK by default
+	ForwardAgent bool   // let remote host use AgentSocket
+	config       ssh.ClientConfig
+	keyAuth      ssh.AuthMethod
+	agentConn    net.Conn
+	noTerminal   bool // dialed by daemon, secrets cannot be asked
 }
 
 func NewSSH(address string, x11 bool, ident string, copyId bool) *SSH {
 	ret := &SSH{
-		X11:       x11,
-		Address:   address,
-		CopyIdOpt: copyId,
-		Identify:  ident,
+		X11:         x11,
+		Address:     address,
+		CopyIdOpt:   copyId,
+		Identify:    ident,
+		AgentSocket: os.Getenv("SSH_AUTH_SOCK"),
 	}
 	ret.ConnectNow = true
 	return ret
@@ -57,9 +73,12 @@ func (s *SSH) Preper() error {
 		HostKeyCallback: ssh.HostKeyCallback(hostKeyCallback),
 		Timeout:         timeout,
 	}
-	s.privateKeyOption()
 	err := s.decodeAddress()
-	return err
+	if err != nil {
+		return err
+	}
+	s.privateKeyOption()
+	return nil
 }
 
 func (s *SSH) Dial() error {
@@ -71,46 +90,223 @@ func (s *SSH) Response() error {
 	defer s.lock.Unlock()
 	cm := conf.NewConfManager("")
 
-	logrus.Debug("Dail local addr ", cm.Conf.LocalSSHPort)
-	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cm.Conf.LocalSSHPort))
+	if cm.Conf().BuiltinSSH {
+		server, err := sshd.NewServer(cm.Path, cm.Conf().AuthorizedKeys)
+		if err != nil {
+			return fmt.Errorf("builtin ssh server: %v", err)
+		}
+		conn := server.Pipe()
+		s.BaseImpl.conn = &conn
+		return nil
+	}
+	logrus.Debug("Dail local addr ", cm.Conf().LocalSSHPort)
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", cm.Conf().LocalSSHPort))
 	if err != nil {
-		return err
+		return fmt.Errorf("sshd unreachable on port %d: %v", cm.Conf().LocalSSHPort, err)
 	}
 	s.BaseImpl.conn = &conn
 	return nil
 }
 
+// privateKeyOption authenticates by keys of identity file, ssh-agent and default
+// identity files in order, an explicitly given identity file must be readable
 func (s *SSH) privateKeyOption() {
+	var signers []ssh.Signer
+	if s.Identify != "" {
+		signer, err := loadIdentity(s.Identify)
+		if err != nil {
+			logrus.Printf("Reading private key file failed %v", err)
+		} else {
+			if key, ok := signer.(*encryptedKey); ok {
+				// fails signing clearly instead of asking
+				key.noTerminal = s.noTerminal
+			}
+			signers = append(signers, signer)
+		}
+	}
+	var defaults []ssh.Signer
 	if s.Identify == "" {
-		s.Identify = path.Join(os.Getenv("HOME"), ".ssh", "id_rsa")
+		for _, name := range defaultIdentities {
+			signer, err := loadIdentity(path.Join(os.Getenv("HOME"), ".ssh", name))
+			if err != nil {
+				logrus.Debug(err)
+				continue
+			}
+			if _, ok := signer.(*encryptedKey); ok && s.noTerminal {
+				logrus.Debug("skip ", name, ", passphrase cannot be asked")
+				continue
+			}
+			defaults = append(defaults, signer)
+		}
 	}
-	pemBytes, err := ioutil.ReadFile(s.Identify)
-	if err != nil {
-		logrus.Printf("Reading private key file failed %v", err)
+	keyring := s.agent()
+	// one callback for all keys, client tries a method only once
+	s.keyAuth = ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+		ret := signers
+		if keyring != nil {
+			agentSigners, err := keyring.Signers()
+			if err != nil {
+				logrus.Debug("cannot list keys of ssh-agent: ", err)
+			}
+			ret = append(ret, agentSigners...)
+		}
+		return append(ret, defaults...), nil
+	})
+}
+
+// agent returns client of AgentSocket, nil if not available
+func (s *SSH) agent() agent.ExtendedAgent {
+	if s.AgentSocket == "" && s.agentConn == nil {
+		return nil
+	}
+	if s.agentConn == nil {
+		conn, err := net.Dial("unix", s.AgentSocket)
+		if err != nil {
+			logrus.Debug("ssh-agent not available: ", err)
+			return nil
+		}
+		s.agentConn = conn
+	}
+	return agent.NewClient(s.agentConn)
+}
+
+// forwardAgent lets remote commands of session use AgentSocket if ForwardAgent set
+func (s *SSH) forwardAgent(client *ssh.Client, session *ssh.Session) {
+	if !s.ForwardAgent {
 		return
 	}
-	// create signer
+	if s.AgentSocket == "" {
+		logrus.Warn("agent forwarding requested but SSH_AUTH_SOCK is not set")
+		return
+	}
+	err := agent.ForwardToRemote(client, s.AgentSocket)
+	if err == nil {
+		err = agent.RequestAgentForwarding(session)
+	}
+	if err != nil {
+		logrus.Warn("agent forwarding failed: ", err)
+	}
+}
+
+// loadIdentity returns signer of private key file, passphrase of an encrypted key
+// is asked once sshd accepted its public key
+func loadIdentity(identity string) (ssh.Signer, error) {
+	pemBytes, err := ioutil.ReadFile(identity)
+	if err != nil {
+		return nil, err
+	}
 	signer, err := SignerFromPem(pemBytes, nil)
+	var missing *ssh.PassphraseMissingError
+	if !errors.As(err, &missing) {
+		return signer, err
+	}
+	if !terminal.IsTerminal(int(syscall.Stdin)) {
+		return nil, fmt.Errorf("%s is encrypted, add it to ssh-agent", identity)
+	}
+	key := &encryptedKey{
+		path:      identity,
+		pemBytes:  pemBytes,
+		publicKey: missing.PublicKey,
+	}
+	if key.publicKey == nil {
+		// public key of PEM format is encrypted too
+		return key.decrypt()
+	}
+	return key, nil
+}
+
+// encryptedKey is a passphrase protected private key, decrypted on first signing
+type encryptedKey struct {
+	path      string
+	pemBytes  []byte
+	publicKey ssh.PublicKey
+	signer    ssh.Signer
+	lock      sync.Mutex
+	// passphrase cannot be asked
+	noTerminal bool
+}
+
+func (k *encryptedKey) PublicKey() ssh.PublicKey {
+	return k.publicKey
+}
+
+func (k *encryptedKey) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	signer, err := k.decrypt()
 	if err != nil {
-		logrus.Error(err)
-		return
+		return nil, err
+	}
+	return signer.Sign(rand, data)
+}
+
+// SignWithAlgorithm keeps rsa-sha2 signatures of rsa keys
+func (k *encryptedKey) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	signer, err := k.decrypt()
+	if err != nil {
+		return nil, err
+	}
+	algorithmSigner, ok := signer.(ssh.AlgorithmSigner)
+	if !ok {
+		return nil, fmt.Errorf("key %s cannot sign with %s", k.path, algorithm)
 	}
-	s.config.Auth = append(s.config.Auth, ssh.PublicKeys(signer))
+	return algorithmSigner.SignWithAlgorithm(rand, data, algorithm)
+}
+
+// decrypt asks passphrase at most NumberOfPrompts times
+func (k *encryptedKey) decrypt() (ssh.Signer, error) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	if k.signer != nil {
+		return k.signer, nil
+	}
+	if k.noTerminal {
+		return nil, errNoTerminal
+	}
+	var err error
+	for i := 0; i < NumberOfPrompts; i++ {
+		var passphrase []byte
+		passphrase, err = readSecret(fmt.Sprintf("Enter passphrase for key '%s': ", k.path))
+		if err != nil {
+			return nil, err
+		}
+		k.signer, err = SignerFromPem(k.pemBytes, passphrase)
+		if err == nil {
+			return k.signer, nil
+		}
+		fmt.Fprintln(os.Stderr, "Bad passphrase")
+	}
+	return nil, err
+}
+
+// resolveAddress splits [username]@[host] and resolves host alias by address book,
+// user and identity are defaults of the address book entry if found
+func resolveAddress(address string) (userName, host, identity string) {
+	host = address
+	if sps := strings.SplitN(address, "@", 2); len(sps) == 2 {
+		userName = sps[0]
+		host = sps[1]
+	}
+	cm := conf.NewConfManager("")
+	if entry := cm.Conf().LookupHost(host); entry != nil {
+		host = entry.ID
+		if userName == "" {
+			userName = entry.User
+		}
+		identity = entry.Identity
+	}
+	return
 }
 
 func (s *SSH) decodeAddress() error {
-	var userName, addr string
-	sps := strings.Split(s.Address, "@")
-	if len(sps) < 2 {
+	userName, addr, identity := resolveAddress(s.Address)
+	if userName == "" {
 		user, err := user.Current()
 		if err != nil {
 			return err
 		}
 		userName = user.Username
-		addr = sps[0]
-	} else {
-		userName = sps[0]
-		addr = sps[1]
+	}
+	if s.Identify == "" {
+		s.Identify = identity
 	}
 	s.config.User = userName
 	s.HId = addr
@@ -120,16 +316,10 @@ func (s *SSH) decodeAddress() error {
 // dial remote sshd with opened wrtc connection
 func (s *SSH) OpenTerminal(conn net.Conn) error {
 	logrus.Debug("dialRemoteAndOpenTerminal")
-	s.config.Auth = append(s.config.Auth, ssh.RetryableAuthMethod(ssh.PasswordCallback(s.passwordCallback), NumberOfPrompts))
-	c, chans, reqs, err := ssh.NewClientConn(conn, "", &s.config)
+	client, err := s.newClient(conn)
 	if err != nil {
 		return err
 	}
-	logrus.Debug("conn ok")
-	client := ssh.NewClient(c, chans, reqs)
-	if client == nil {
-		return fmt.Errorf("cannot create ssh client")
-	}
 	logrus.Debug("client ok")
 	session, err := client.NewSession()
 	if err != nil {
@@ -159,6 +349,7 @@ func (s *SSH) OpenTerminal(conn net.Conn) error {
 		logrus.Debug("x11 enable")
 		x11Request(session, client)
 	}
+	s.forwardAgent(client, session)
 	fd := int(os.Stdin.Fd())
 	state, err := terminal.MakeRaw(fd)
 	if err != nil {
@@ -180,6 +371,7 @@ func (s *SSH) OpenTerminal(conn net.Conn) error {
 	if err := session.RequestPty(term, h, w, modes); err != nil {
 		return err
 	}
+	defer forwardWindowSize(session, fd)()
 	logrus.Debug("pty ok")
 	session.Stdout = os.Stdout
 	session.Stderr = os.Stderr
@@ -196,15 +388,180 @@ func (s *SSH) OpenTerminal(conn net.Conn) error {
 
 }
 
-func (dal *SSH) passwordCallback() (string, error) {
+// newClient logs in remote sshd over opened connection
+func (s *SSH) newClient(conn net.Conn) (*ssh.Client, error) {
+	config := s.config
+	config.Auth = []ssh.AuthMethod{s.keyAuth}
+	if s.noTerminal {
+		config.Auth = append(config.Auth,
+			ssh.KeyboardInteractive(noKeyboardInteractive),
+			ssh.PasswordCallback(func() (string, error) { return "", errNoTerminal }),
+		)
+	} else {
+		config.Auth = append(config.Auth,
+			ssh.RetryableAuthMethod(ssh.KeyboardInteractive(keyboardInteractive), NumberOfPrompts),
+			ssh.RetryableAuthMethod(ssh.PasswordCallback(passwordCallback), NumberOfPrompts),
+		)
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, "", &config)
+	if s.agentConn != nil {
+		// keys are listed while logging in, forwarding dials agent itself
+		s.agentConn.Close()
+		s.agentConn = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// Exec runs Command on remote sshd with opened connection and returns its exit status.
+// Stdout and stderr of Command are kept apart unless a pty is requested by Tty.
+func (s *SSH) Exec(conn net.Conn) (int, error) {
+	client, err := s.newClient(conn)
+	if err != nil {
+		return -1, err
+	}
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		return -1, err
+	}
+	defer session.Close()
+	s.forwardAgent(client, session)
+	if s.Tty {
+		w, h := 80, 24
+		fd := int(os.Stdin.Fd())
+		if terminal.IsTerminal(fd) {
+			state, err := terminal.MakeRaw(fd)
+			if err != nil {
+				return -1, err
+			}
+			defer terminal.Restore(fd, state)
+			if w, h, err = terminal.GetSize(fd); err != nil {
+				return -1, err
+			}
+		}
+		term := os.Getenv("TERM")
+		if term == "" {
+			term = "xterm-256color"
+		}
+		modes := ssh.TerminalModes{
+			ssh.ECHO:          1,
+			ssh.TTY_OP_ISPEED: 14400,
+			ssh.TTY_OP_OSPEED: 14400,
+		}
+		if err := session.RequestPty(term, h, w, modes); err != nil {
+			return -1, err
+		}
+		if terminal.IsTerminal(fd) {
+			defer forwardWindowSize(session, fd)()
+		}
+	}
+	session.Stdin = os.Stdin
+	session.Stdout = os.Stdout
+	session.Stderr = os.Stderr
+	err = session.Run(s.Command)
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitStatus(), nil
+	}
+	if err != nil {
+		return -1, err
+	}
+	return 0, nil
+}
+
+// forwardWindowSize tells remote pty of session about size changes of terminal fd until stop is called
+func forwardWindowSize(session *ssh.Session, fd int) (stop func()) {
+	return watchWindowSize(fd, func(width, height int) {
+		if err := session.WindowChange(height, width); err != nil {
+			logrus.Debug(err)
+		}
+	})
+}
+
+func passwordCallback() (string, error) {
 	logrus.Debug("password callback")
-	fmt.Print("Password: ")
-	b, _ := terminal.ReadPassword(int(syscall.Stdin))
-	fmt.Print("\n")
-	dal.config.Auth = append(dal.config.Auth, ssh.Password(string(b)))
+	// stdout may be output of a remote command
+	b, err := readSecret("Password: ")
+	if err != nil {
+		return "", err
+	}
 	return string(b), nil
 }
 
+// keyboardInteractive answers challenges of sshd, e.g. one-time passwords
+func keyboardInteractive(name, instruction string, questions []string, echos []bool) ([]string, error) {
+	logrus.Debug("keyboard interactive callback")
+	if name != "" {
+		fmt.Fprintln(os.Stderr, name)
+	}
+	if instruction != "" {
+		fmt.Fprintln(os.Stderr, instruction)
+	}
+	answers := make([]string, len(questions))
+	for i, question := range questions {
+		var answer []byte
+		var err error
+		if echos[i] {
+			answer, err = readLine(question)
+		} else {
+			answer, err = readSecret(question)
+		}
+		if err != nil {
+			return nil, err
+		}
+		answers[i] = string(answer)
+	}
+	return answers, nil
+}
+
+// noKeyboardInteractive only passes rounds without questions
+func noKeyboardInteractive(name, instruction string, questions []string, echos []bool) ([]string, error) {
+	if len(questions) > 0 {
+		return nil, errNoTerminal
+	}
+	return nil, nil
+}
+
+// readSecret prompts on stderr and reads a line from terminal without echo
+func readSecret(prompt string) ([]byte, error) {
+	fd := int(syscall.Stdin)
+	if !terminal.IsTerminal(fd) {
+		return nil, fmt.Errorf("no terminal to ask %q", strings.TrimSpace(prompt))
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	defer fmt.Fprint(os.Stderr, "\n")
+	return terminal.ReadPassword(fd)
+}
+
+// readLine prompts on stderr and reads a line from terminal
+func readLine(prompt string) ([]byte, error) {
+	if !terminal.IsTerminal(int(syscall.Stdin)) {
+		return nil, fmt.Errorf("no terminal to ask %q", strings.TrimSpace(prompt))
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	// byte by byte, rest of stdin belongs to remote
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := os.Stdin.Read(b)
+		if n == 1 && b[0] == '\n' {
+			return line, nil
+		}
+		if n == 1 && b[0] != '\r' {
+			line = append(line, b[0])
+		}
+		if err == io.EOF {
+			return line, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
 func createKnownHosts() {
 	f, fErr := os.OpenFile(path.Join(os.Getenv("HOME"), ".ssh", "known_hosts"), os.O_CREATE, 0600)
 	if fErr != nil {
@@ -345,9 +702,16 @@ func SignerFromPem(pemBytes []byte, password []byte) (ssh.Signer, error) {
 		return nil, err
 	}
 
+	if len(password) > 0 {
+		signer, err := ssh.ParsePrivateKeyWithPassphrase(pemBytes, password)
+		if err != nil {
+			return nil, fmt.Errorf("parsing encrypted private key failed %w", err)
+		}
+		return signer, nil
+	}
 	signer, err := ssh.ParsePrivateKey(pemBytes)
 	if err != nil {
-		return nil, fmt.Errorf("parsing plain private key failed %v", err)
+		return nil, fmt.Errorf("parsing plain private key failed %w", err)
 	}
 
 	return signer, nil
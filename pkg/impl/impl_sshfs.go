@@ -2,6 +2,8 @@ package impl
 
 import (
 	"fmt"
+	"net"
+	"os"
 
 	fusefs "github.com/hanwen/go-fuse/v2/fs"
 	"github.com/pkg/sftp"
@@ -9,24 +11,26 @@ import (
 	"github.com/suutaku/go-sshfs/pkg/sshfs"
 	"github.com/suutaku/sshx/internal/utils"
 	"github.com/suutaku/sshx/pkg/types"
-	"golang.org/x/crypto/ssh"
 )
 
 type SSHFS struct {
 	BaseImpl
-	MountPoint string
-	Root       string
-	Address    string
-	sshfs      *sshfs.Sshfs
-	Identify   string
+	MountPoint  string
+	Root        string
+	Address     string
+	sshfs       *sshfs.Sshfs
+	Identify    string
+	AgentSocket string // ssh-agent of the user, mounted by daemon
+	agentConn   net.Conn
 }
 
 func NewSSHFS(mountPoint, root, address, id string) *SSHFS {
 	return &SSHFS{
-		MountPoint: mountPoint,
-		Root:       root,
-		Address:    address,
-		Identify:   id,
+		MountPoint:  mountPoint,
+		Root:        root,
+		Address:     address,
+		Identify:    id,
+		AgentSocket: os.Getenv("SSH_AUTH_SOCK"),
 	}
 }
 
@@ -37,6 +41,9 @@ func (fs *SSHFS) Preper() error {
 	if err != nil {
 		return err
 	}
+	// keep resolved address, aliases may be unknown to daemon
+	fs.Address = ssht.config.User + "@" + ssht.HId
+	fs.Identify = ssht.Identify
 	fs.HId = ssht.HId
 	return nil
 }
@@ -45,8 +52,23 @@ func (fs *SSHFS) Code() int32 {
 	return types.APP_TYPE_SFS
 }
 
+func (fs *SSHFS) agentSocket() string {
+	return fs.AgentSocket
+}
+
+func (fs *SSHFS) setAgentConn(conn net.Conn) {
+	fs.agentConn = conn
+}
+
 func (fs *SSHFS) Dial() error {
 	ssht := NewSSH(fs.Address, false, fs.Identify, false)
+	// agent of daemon or socket path of request may belong to other users
+	ssht.AgentSocket = ""
+	ssht.agentConn = fs.agentConn
+	if fs.agentConn != nil {
+		defer fs.agentConn.Close()
+	}
+	ssht.noTerminal = true
 	err := ssht.Preper()
 	if err != nil {
 		return err
@@ -65,15 +87,10 @@ func (fs *SSHFS) Dial() error {
 	// 	closeSender.PairId = sender.PairId
 	// 	closeSender.SendDetach()
 	// }()
-	ssht.config.Auth = append(ssht.config.Auth, ssh.RetryableAuthMethod(ssh.PasswordCallback(ssht.passwordCallback), NumberOfPrompts))
-	c, chans, reqs, err := ssh.NewClientConn(conn, "", &ssht.config)
+	sshClient, err := ssht.newClient(conn)
 	if err != nil {
 		return err
 	}
-	sshClient := ssh.NewClient(c, chans, reqs)
-	if sshClient == nil {
-		return fmt.Errorf("cannot create ssh client")
-	}
 	sftpClient, err := sftp.NewClient(sshClient)
 	if err != nil {
 		return err
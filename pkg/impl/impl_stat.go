@@ -60,14 +60,14 @@ func (stat *STAT) ShowStatus(displayType int) {
 func (stat *STAT) showTable(status []types.Status) {
 	t := table.NewWriter()
 	t.SetOutputMirror(os.Stdout)
-	t.AppendHeader(table.Row{"#", "Pair ID", "Target ID", "Parent Pair ID", "Application", "Start At"})
+	t.AppendHeader(table.Row{"#", "Pair ID", "Target ID", "Parent Pair ID", "Application", "Transport", "Start At"})
 	t.AppendSeparator()
 	for k, v := range status {
 		if v.ParentPairId == "" {
 			v.ParentPairId = "NULL"
 		}
 		t.AppendRows([]table.Row{
-			{k + 1, v.PairId, v.TargetId, v.ParentPairId, GetImplName(v.ImplType), v.StartTime.Format("2 Jan 2006 15:04:05")},
+			{k + 1, v.PairId, v.TargetId, v.ParentPairId, GetImplName(v.ImplType), v.Transport, v.StartTime.Format("2 Jan 2006 15:04:05")},
 		})
 	}
 	t.AppendSeparator()
@@ -31,7 +31,7 @@ func (vnc *VNC) Response() error {
 	vnc.lock.Unlock()
 	defer vnc.lock.Unlock()
 	cm := conf.NewConfManager("")
-	localAddr := fmt.Sprintf("ws://%s:%d", cm.Conf.VNCConf.Websockify.Host, cm.Conf.VNCConf.Websockify.Port)
+	localAddr := fmt.Sprintf("ws://%s:%d", cm.Conf().VNCConf.Websockify.Host, cm.Conf().VNCConf.Websockify.Port)
 	logrus.Debug("VNCResponser response ", localAddr)
 	vncConn, _, err := websocket.DefaultDialer.Dial(localAddr, nil)
 	if err != nil {
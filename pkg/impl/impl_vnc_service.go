@@ -55,13 +55,13 @@ func (vnc *VNCService) Dial() error {
 	vnc.Running = true
 	cm := conf.NewConfManager("")
 	if vnc.VNCConf == nil {
-		vnc.VNCConf = &cm.Conf.VNCConf
+		vnc.VNCConf = &cm.Conf().VNCConf
 	}
-	if vnc.serviceIsRuning(cm.Conf.LocalHTTPPort) {
+	if vnc.serviceIsRuning(cm.Conf().LocalHTTPPort) {
 		return fmt.Errorf("vnc service was already running")
 	}
 	r := mux.NewRouter()
-	r.Handle("/", http.FileServer(http.Dir(cm.Conf.VNCStaticPath)))
+	r.Handle("/", http.FileServer(http.Dir(cm.Conf().VNCStaticPath)))
 	r.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
 		deviceId := r.URL.Query()["device"]
 		logrus.Debug(deviceId)
@@ -94,8 +94,8 @@ func (vnc *VNCService) Dial() error {
 		logrus.Debug("end of gorutine")
 
 	})
-	logrus.Info("servce http at port ", cm.Conf.LocalHTTPPort)
-	srv := &http.Server{Addr: fmt.Sprintf(":%d", cm.Conf.LocalHTTPPort), Handler: r}
+	logrus.Info("servce http at port ", cm.Conf().LocalHTTPPort)
+	srv := &http.Server{Addr: fmt.Sprintf(":%d", cm.Conf().LocalHTTPPort), Handler: r}
 	vnc.httpServer = srv
 	vnc.vncServer = vncgo.NewVNC(context.Background(), *vnc.VNCConf)
 	go vnc.vncServer.Start()
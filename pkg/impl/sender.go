@@ -2,14 +2,23 @@ package impl
 
 import (
 	"bytes"
+	"context"
 	"encoding/gob"
 	"fmt"
 	"net"
+	"os"
+	"os/signal"
+	"time"
 
 	"github.com/sirupsen/logrus"
+	"github.com/suutaku/sshx/internal/utils"
 	"github.com/suutaku/sshx/pkg/conf"
+	"github.com/suutaku/sshx/pkg/types"
 )
 
+// daemon gives up connection setup before this
+const responseTimeout = 45 * time.Second
+
 // Request struct which send to Local TCP listenner
 type Sender struct {
 	Type       int32 // Request type defined on types
@@ -17,10 +26,20 @@ type Sender struct {
 	Detach     bool
 	LocalEntry string
 	Payload    []byte // Application specify payload
-	Status     int32
+	Status     int32  // status code defined on types, set by daemon
+	Message    string // reason of failed status
+	// unix socket of daemon, LocalEntry is used where not supported
+	socket string
+	// ssh-agent of requester checked by daemon, not sent
+	agentConn net.Conn
 }
 
 func NewSender(imp Impl, optCode int32) *Sender {
+	return NewSenderWithConf(conf.NewConfManager(""), imp, optCode)
+}
+
+// NewSenderWithConf returns sender to daemon configured by cm
+func NewSenderWithConf(cm *conf.ConfManager, imp Impl, optCode int32) *Sender {
 	if imp.HostId() == "" {
 		logrus.Warn("Host Id not set, maybe you should set it on Preper")
 	}
@@ -34,8 +53,8 @@ func NewSender(imp Impl, optCode int32) *Sender {
 		return nil
 	}
 	ret.Payload = buf.Bytes()
-	cm := conf.NewConfManager("")
-	ret.LocalEntry = fmt.Sprintf("127.0.0.1:%d", cm.Conf.LocalTCPPort)
+	ret.LocalEntry = fmt.Sprintf("127.0.0.1:%d", cm.Conf().LocalTCPPort)
+	ret.socket = cm.ControlSocket()
 	ret.PairId = []byte(imp.PairId())
 	return ret
 }
@@ -55,30 +74,93 @@ func (sender *Sender) GetImpl() Impl {
 	if err != nil {
 		logrus.Error(err)
 	}
+	if v, ok := impl.(agentUser); ok {
+		v.setAgentConn(sender.agentConn)
+	}
 	return impl
 }
 
+// AgentSocket returns ssh-agent socket of requester if application uses it inside daemon
+func (sender *Sender) AgentSocket() string {
+	v, ok := GetImpl(sender.GetAppCode()).(agentUser)
+	if !ok {
+		return ""
+	}
+	gob.NewDecoder(bytes.NewBuffer(sender.Payload)).Decode(v)
+	return v.agentSocket()
+}
+
+// SetAgentConn gives application connection to ssh-agent of requester
+func (sender *Sender) SetAgentConn(conn net.Conn) {
+	sender.agentConn = conn
+}
+
+// dial connects to daemon by unix socket, by LocalEntry if socket not supported
+func (sender *Sender) dial(ctx context.Context) (net.Conn, error) {
+	var d net.Dialer
+	if sender.socket != "" {
+		return d.DialContext(ctx, "unix", sender.socket)
+	}
+	return d.DialContext(ctx, "tcp", sender.LocalEntry)
+}
+
+// Send waits for response of daemon at most responseTimeout, Ctrl-C aborts waiting
 func (sender *Sender) Send() (net.Conn, error) {
-	conn, err := net.Dial("tcp", sender.LocalEntry)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, responseTimeout)
+	defer cancel()
+	return sender.SendContext(ctx)
+}
+
+// SendContext sends request and waits for response of daemon until ctx done,
+// failures reported by daemon are returned as *types.Error
+func (sender *Sender) SendContext(ctx context.Context) (net.Conn, error) {
+	conn, err := sender.dial(ctx)
 	if err != nil {
 		return nil, err
 	}
-	err = gob.NewEncoder(conn).Encode(sender)
+	stop := make(chan struct{})
+	canceled := make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+			canceled <- true
+		case <-stop:
+			canceled <- false
+		}
+	}()
+	err = sender.exchange(conn)
+	close(stop)
+	if <-canceled {
+		return nil, ctx.Err()
+	}
 	if err != nil {
+		conn.Close()
 		return nil, err
 	}
+	return conn, nil
+}
+
+func (sender *Sender) exchange(conn net.Conn) error {
+	err := gob.NewEncoder(conn).Encode(sender)
+	if err != nil {
+		return err
+	}
 	logrus.Debug("waiting TCP Responnse")
 
-	err = gob.NewDecoder(conn).Decode(sender)
+	// read byte by byte, data of impl may follow the response
+	err = gob.NewDecoder(utils.ByteReader{Reader: conn}).Decode(sender)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	logrus.Debug("TCP Responnse OK ", string(sender.PairId))
-	if sender.Status != 0 {
-		return nil, fmt.Errorf("response error")
+	if sender.Status != types.STATUS_OK {
+		return &types.Error{Code: sender.Status, Message: sender.Message}
 	}
-	return conn, nil
+	return nil
 }
 
 func (sender *Sender) SendDetach() (net.Conn, error) {
@@ -0,0 +1,38 @@
+//go:build !windows
+
+package impl
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/term"
+)
+
+// watchWindowSize calls resize with new size of terminal fd on SIGWINCH until stop is called
+func watchWindowSize(fd int, resize func(width, height int)) (stop func()) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGWINCH)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-sigs:
+			}
+			width, height, err := term.GetSize(fd)
+			if err != nil {
+				logrus.Debug(err)
+				continue
+			}
+			resize(width, height)
+		}
+	}()
+	return func() {
+		signal.Stop(sigs)
+		close(done)
+	}
+}
@@ -0,0 +1,36 @@
+package impl
+
+import (
+	"time"
+
+	"golang.org/x/term"
+)
+
+// console of windows has no SIGWINCH, size is polled
+const windowPollInterval = 500 * time.Millisecond
+
+// watchWindowSize calls resize with new size of terminal fd until stop is called
+func watchWindowSize(fd int, resize func(width, height int)) (stop func()) {
+	lastWidth, lastHeight, _ := term.GetSize(fd)
+	ticker := time.NewTicker(windowPollInterval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			width, height, err := term.GetSize(fd)
+			if err != nil || (width == lastWidth && height == lastHeight) {
+				continue
+			}
+			lastWidth, lastHeight = width, height
+			resize(width, height)
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
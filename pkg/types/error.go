@@ -0,0 +1,59 @@
+package types
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var statusText = map[int32]string{
+	STATUS_OK:           "ok",
+	STATUS_FAILED:       "failed",
+	STATUS_PEER_OFFLINE: "peer offline",
+	STATUS_ACL_DENIED:   "ACL denied",
+	STATUS_IMPL_UNKNOWN: "unknown application",
+	STATUS_ICE_FAILED:   "ICE failed",
+	STATUS_TIMEOUT:      "timeout",
+	STATUS_REMOTE_ERROR: "remote application error",
+	STATUS_NOT_FOUND:    "session not found",
+}
+
+// StatusText returns description of status code
+func StatusText(code int32) string {
+	if text, ok := statusText[code]; ok {
+		return text
+	}
+	return statusText[STATUS_FAILED]
+}
+
+// Error is a failed request with its status code
+type Error struct {
+	Code    int32
+	Message string
+}
+
+func NewError(code int32, format string, a ...interface{}) *Error {
+	return &Error{
+		Code:    code,
+		Message: fmt.Sprintf(format, a...),
+	}
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return StatusText(e.Code)
+	}
+	return StatusText(e.Code) + ": " + e.Message
+}
+
+// ErrorStatus returns status code and message of err, STATUS_FAILED for unclassified errors
+func ErrorStatus(err error) (int32, string) {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code, e.Message
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return STATUS_TIMEOUT, "connection setup took too long"
+	}
+	return STATUS_FAILED, err.Error()
+}
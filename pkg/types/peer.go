@@ -0,0 +1,15 @@
+package types
+
+import "time"
+
+// Version of sshx, set by -ldflags "-X github.com/suutaku/sshx/pkg/types.Version=..."
+var Version = "dev"
+
+// PeerInfo announces a node on signaling server
+type PeerInfo struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Version  string    `json:"version"`
+	Impls    []int32   `json:"impls"`
+	LastSeen time.Time `json:"last_seen"`
+}
@@ -24,3 +24,13 @@ func (pd *PoolId) String(direct int32) string {
 func (pd *PoolId) Raw() int64 {
 	return pd.Value
 }
+
+// ParsePoolId parses pool id from the output of String
+func ParsePoolId(str string) (*PoolId, error) {
+	ret := &PoolId{}
+	_, err := fmt.Sscanf(str, "conn_%d_%d_%d", &ret.ImplCode, &ret.Value, &ret.Direction)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pool id %s: %v", str, err)
+	}
+	return ret, nil
+}
@@ -1,5 +1,7 @@
 package types
 
+import "encoding/json"
+
 type SignalingInfo struct {
 	Flag              int    `json:"flag"`
 	Source            string `json:"source"`
@@ -9,4 +11,15 @@ type SignalingInfo struct {
 	Target            string `json:"target"`
 	PeerType          int32  `json:"peer_type"`
 	RemoteRequestType int32  `json:"remote_request_type"`
+	Message           string `json:"message"`
+	Code              int32  `json:"code,omitempty"` // status code of reject
+	Timestamp         int64  `json:"timestamp"`
+	Signature         []byte `json:"signature"`
+}
+
+// Digest returns the bytes covered by Signature
+func (info SignalingInfo) Digest() []byte {
+	info.Signature = nil
+	bs, _ := json.Marshal(info)
+	return bs
 }
@@ -8,4 +8,6 @@ type Status struct {
 	ImplType     int32
 	PairId       string
 	ParentPairId string
+	// transport which carries the pair, e.g. direct or webrtc
+	Transport string
 }
@@ -0,0 +1,10 @@
+package types
+
+// TURNCredential is a time-limited TURN credential issued by signaling server,
+// fields follow the TURN REST API draft
+type TURNCredential struct {
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	TTL      int64    `json:"ttl"`
+	URIs     []string `json:"uris"`
+}
@@ -7,6 +7,19 @@ const (
 	OPTION_TYPE_ATTACH
 )
 
+// status of response to CLI, 0 means success
+const (
+	STATUS_OK = iota
+	STATUS_FAILED
+	STATUS_PEER_OFFLINE
+	STATUS_ACL_DENIED
+	STATUS_IMPL_UNKNOWN
+	STATUS_ICE_FAILED
+	STATUS_TIMEOUT
+	STATUS_REMOTE_ERROR
+	STATUS_NOT_FOUND
+)
+
 const (
 	APP_TYPE_SSH = iota
 	APP_TYPE_VNC
@@ -26,4 +39,6 @@ const (
 	SIG_TYPE_CANDIDATE
 	SIG_TYPE_ANSWER
 	SIG_TYPE_OFFER
+	SIG_TYPE_REJECT
+	SIG_TYPE_RESTART
 )